@@ -0,0 +1,202 @@
+package config
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Signing algorithms supported for private_key_jwt client assertions.
+const (
+	ClientAssertionRS256 = "RS256"
+	ClientAssertionRS384 = "RS384"
+	ClientAssertionPS256 = "PS256"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// clientAssertionTTL is how long a client assertion is valid for, it is
+	// only used to request a single access token.
+	clientAssertionTTL = time.Minute
+)
+
+// usesClientAssertion returns true if the management client authenticates
+// with a private_key_jwt client assertion instead of the client secret.
+func (c *Auth0Config) usesClientAssertion() bool {
+	return c.ClientAssertionKey != "" || c.ClientAssertionKeyFile != ""
+}
+
+// clientAssertionAlg returns the algorithm client assertions are signed
+// with.
+func (c *Auth0Config) clientAssertionAlg() string {
+	if c.ClientAssertionAlg == "" {
+		return ClientAssertionRS256
+	}
+	return c.ClientAssertionAlg
+}
+
+// clientAssertionKey reads the private key client assertions are signed
+// with, from the configuration or from the key file.
+func (c *Auth0Config) clientAssertionKey() (*rsa.PrivateKey, error) {
+	data := []byte(c.ClientAssertionKey)
+	if c.ClientAssertionKeyFile != "" {
+		var err error
+		data, err = os.ReadFile(c.ClientAssertionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parsePrivateKey(data)
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key was found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported %T private key; expected an RSA key", key)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q; expected an RSA PRIVATE KEY or a PRIVATE KEY", block.Type)
+	}
+}
+
+// tenantURL returns the URL of the tenant at domain, with a trailing slash,
+// ignoring the scheme of the domain like management.New does.
+func tenantURL(domain string) string {
+	if i := strings.Index(domain, "//"); i != -1 {
+		domain = domain[i+2:]
+	}
+	return "https://" + strings.TrimSuffix(domain, "/") + "/"
+}
+
+// assertionTokenSource requests Management API access tokens with the
+// client credentials grant, authenticating with a private_key_jwt client
+// assertion signed for every request.
+type assertionTokenSource struct {
+	ctx      context.Context
+	tokenURL string
+	audience string
+	// issuer is the URL of the tenant issuing the tokens, the audience of
+	// the client assertions.
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+	keyID    string
+	alg      string
+}
+
+func (s *assertionTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := s.assertion(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+
+	cfg := &clientcredentials.Config{
+		ClientID:  s.clientID,
+		TokenURL:  s.tokenURL,
+		AuthStyle: oauth2.AuthStyleInParams,
+		EndpointParams: url.Values{
+			"audience":              {s.audience},
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+		},
+	}
+
+	return cfg.Token(s.ctx)
+}
+
+// assertion returns a client assertion JWT issued at now.
+func (s *assertionTokenSource) assertion(now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": s.alg, "typ": "JWT"}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+
+	claims := map[string]interface{}{
+		"iss": s.clientID,
+		"sub": s.clientID,
+		"aud": s.issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionTTL).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+
+	encodedHeader, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	signature, err := sign(s.alg, s.key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// sign signs the JWT signing input with the given algorithm.
+func sign(alg string, key *rsa.PrivateKey, input []byte) ([]byte, error) {
+	switch alg {
+	case ClientAssertionRS256:
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ClientAssertionRS384:
+		digest := sha512.Sum384(input)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA384, digest[:])
+	case ClientAssertionPS256:
+		digest := sha256.Sum256(input)
+		return rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
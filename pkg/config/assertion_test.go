@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/ratelimit"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	data, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}))
+}
+
+// verifyAssertion checks the signature of a client assertion and returns its
+// header and claims.
+func verifyAssertion(t *testing.T, assertion string, key *rsa.PublicKey) (map[string]interface{}, map[string]interface{}) {
+	parts := strings.Split(assertion, ".")
+	require.Equal(t, 3, len(parts))
+
+	decode := func(segment string) map[string]interface{} {
+		data, err := base64.RawURLEncoding.DecodeString(segment)
+		require.NoError(t, err)
+		values := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(data, &values))
+		return values
+	}
+	header, claims := decode(parts[0]), decode(parts[1])
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+
+	input := []byte(parts[0] + "." + parts[1])
+	switch header["alg"] {
+	case ClientAssertionRS256:
+		digest := sha256.Sum256(input)
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	case ClientAssertionRS384:
+		digest := sha512.Sum384(input)
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA384, digest[:], signature)
+	case ClientAssertionPS256:
+		digest := sha256.Sum256(input)
+		err = rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default:
+		t.Fatalf("unexpected algorithm %v", header["alg"])
+	}
+	require.NoError(t, err)
+
+	return header, claims
+}
+
+func TestClientAssertion(t *testing.T) {
+	assert := require.New(t)
+	key, _ := generateKey(t)
+	now := time.Now()
+
+	for _, alg := range []string{ClientAssertionRS256, ClientAssertionRS384, ClientAssertionPS256} {
+		source := &assertionTokenSource{issuer: "https://tenant.auth0.com/", clientID: "id", key: key, keyID: "kid1", alg: alg}
+
+		assertion, err := source.assertion(now)
+		assert.NoError(err)
+
+		header, claims := verifyAssertion(t, assertion, &key.PublicKey)
+		assert.Equal(map[string]interface{}{"alg": alg, "typ": "JWT", "kid": "kid1"}, header)
+		assert.Equal("id", claims["iss"])
+		assert.Equal("id", claims["sub"])
+		assert.Equal("https://tenant.auth0.com/", claims["aud"])
+		assert.Equal(float64(now.Unix()), claims["iat"])
+		assert.Equal(float64(now.Add(time.Minute).Unix()), claims["exp"])
+		assert.NotEmpty(claims["jti"])
+
+		other, err := source.assertion(now)
+		assert.NoError(err)
+		_, otherClaims := verifyAssertion(t, other, &key.PublicKey)
+		assert.NotEqual(claims["jti"], otherClaims["jti"], "every assertion should have its own id")
+	}
+}
+
+func TestNewManagementWithClientAssertion(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+	key, pemKey := generateKey(t)
+
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	assert.NoError(os.WriteFile(keyFile, []byte(pemKey), 0o600))
+
+	config := Auth0Config{
+		Domain:                 fake.Domain(),
+		ClientID:               "id",
+		ClientAssertionKeyFile: keyFile,
+		ClientAssertionAlg:     ClientAssertionPS256,
+	}
+	assert.NoError(config.Validate(plugin.OperationTypeWrite))
+
+	mgmt, err := config.NewManagement(ratelimit.NewTransport(nil))
+	assert.NoError(err)
+	_, err = mgmt.Connection.ReadByName("Username-Password-Authentication")
+	assert.NoError(err)
+
+	requests := fake.TokenRequests()
+	assert.NotEmpty(requests)
+	request := requests[len(requests)-1]
+	assert.Equal("client_credentials", request.Get("grant_type"))
+	assert.Equal("id", request.Get("client_id"))
+	assert.Empty(request.Get("client_secret"))
+	assert.Equal("https://"+fake.Domain()+"/api/v2/", request.Get("audience"))
+	assert.Equal("urn:ietf:params:oauth:client-assertion-type:jwt-bearer", request.Get("client_assertion_type"))
+
+	_, claims := verifyAssertion(t, request.Get("client_assertion"), &key.PublicKey)
+	assert.Equal("https://"+fake.Domain()+"/", claims["aud"])
+}
+
+func TestValidateClientAssertion(t *testing.T) {
+	assert := require.New(t)
+	_, pemKey := generateKey(t)
+
+	configs := map[string]Auth0Config{
+		"a client secret and a client assertion key were provided; please specify only one": {
+			ClientSecret: "secret", ClientAssertionKey: pemKey,
+		},
+		"a client assertion key and a client assertion key file were provided; please specify only one": {
+			ClientAssertionKey: pemKey, ClientAssertionKeyFile: "key.pem",
+		},
+		`invalid client assertion signing algorithm "HS256"; expected one of RS256, RS384 or PS256`: {
+			ClientAssertionKey: pemKey, ClientAssertionAlg: "HS256",
+		},
+		"invalid client assertion key: no PEM encoded key was found": {
+			ClientAssertionKey: "key",
+		},
+		"a client assertion key id or signing algorithm was provided without a client assertion key": {
+			ClientSecret: "secret", ClientAssertionKeyID: "kid1",
+		},
+	}
+
+	for expected, config := range configs {
+		config.Domain = "domain"
+		config.ClientID = "id"
+
+		err := config.Validate(plugin.OperationTypeRead)
+		assert.Error(err, expected)
+		assert.Equal("rpc error: code = InvalidArgument desc = "+expected, err.Error())
+	}
+}
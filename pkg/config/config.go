@@ -1,7 +1,20 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/filter"
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/ratelimit"
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/transform"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"gopkg.in/auth0.v5/management"
@@ -18,43 +31,301 @@ func GetVersion() (string, string, string) {
 	return ver, date, commit
 }
 
+const (
+	// maxPageSize is the largest page the Auth0 list endpoints return.
+	maxPageSize        = 100
+	maxReadConcurrency = 20
+)
+
+// Read modes supported by the plugin.
+const (
+	// ReadModeAuto pages through the users and switches to an export job when
+	// the tenant has more users than the list endpoint can return.
+	ReadModeAuto = "auto"
+	// ReadModePage always pages through the users list endpoint.
+	ReadModePage = "page"
+	// ReadModeExport always reads the users using an export job.
+	ReadModeExport = "export"
+)
+
+// App metadata mappings supported by the plugin.
+const (
+	// AppMetadataNone ignores the Auth0 app_metadata.
+	AppMetadataNone = "none"
+	// AppMetadataWhole maps the whole app_metadata to a single application.
+	AppMetadataWhole = "whole"
+	// AppMetadataPerApplication maps every app_metadata object to the
+	// application with the same name, and the other app_metadata values to
+	// the _app_metadata application.
+	AppMetadataPerApplication = "per-application"
+)
+
+// Reconcile modes supported by the plugin.
+const (
+	// ReconcileNone leaves the users missing from the written users alone.
+	ReconcileNone = "none"
+	// ReconcileDelete deletes the users of the connection missing from the
+	// written users.
+	ReconcileDelete = "delete"
+	// ReconcileBlock blocks the users of the connection missing from the
+	// written users.
+	ReconcileBlock = "block"
+)
+
 type Auth0Config struct {
-	Domain         string `description:"Auth0 domain" kind:"attribute" mode:"normal" readonly:"false" name:"domain"`
-	ClientID       string `description:"Auth0 Client ID" kind:"attribute" mode:"normal" readonly:"false" name:"client-id"`
-	ClientSecret   string `description:"Auth0 Client Secret" kind:"attribute" mode:"normal" readonly:"false" name:"client-secret"`
-	ConnectionName string `description:"Auth0 database connection name" kind:"attribute" mode:"normal" readonly:"false" name:"connection-name"`
-	UserPID        string `description:"Auth0 User PID of the user you want to read" kind:"attribute" mode:"normal" readonly:"false" name:"user-pid"`
-	UserEmail      string `description:"Auth0 User email of the user you want to read" kind:"attribute" mode:"normal" readonly:"false" name:"user-email"`
+	Domain                 string `description:"Auth0 domain" kind:"attribute" mode:"normal" readonly:"false" name:"domain"`
+	ClientID               string `description:"Auth0 Client ID" kind:"attribute" mode:"normal" readonly:"false" name:"client-id"`
+	ClientSecret           string `description:"Auth0 Client Secret, or a reference to it like env:AUTH0_CLIENT_SECRET or file:/run/secrets/auth0" kind:"attribute" mode:"normal" readonly:"false" name:"client-secret"`
+	ClientAssertionKey     string `description:"PEM encoded RSA private key used instead of the client secret to sign private_key_jwt client assertions" kind:"attribute" mode:"normal" readonly:"false" name:"client-assertion-key"`
+	ClientAssertionKeyFile string `description:"File holding the PEM encoded RSA private key used instead of the client secret to sign private_key_jwt client assertions" kind:"attribute" mode:"normal" readonly:"false" name:"client-assertion-key-file"`
+	ClientAssertionKeyID   string `description:"Key id of the client assertion signing key registered in Auth0" kind:"attribute" mode:"normal" readonly:"false" name:"client-assertion-key-id"`
+	ClientAssertionAlg     string `description:"Algorithm client assertions are signed with: RS256, RS384 or PS256, RS256 when empty" kind:"attribute" mode:"normal" readonly:"false" name:"client-assertion-alg"`
+	AccessToken            string `description:"Pre-issued Management API access token used instead of client credentials" kind:"attribute" mode:"normal" readonly:"false" name:"access-token"`
+	Audience               string `description:"Audience of the requested Management API access tokens, https://<domain>/api/v2/ when empty" kind:"attribute" mode:"normal" readonly:"false" name:"audience"`
+	TokenEndpoint          string `description:"URL Management API access tokens are requested from, https://<domain>/oauth/token when empty" kind:"attribute" mode:"normal" readonly:"false" name:"token-endpoint"`
+	ConnectionName         string `description:"Auth0 database connection name" kind:"attribute" mode:"normal" readonly:"false" name:"connection-name"`
+	UserPID                string `description:"Auth0 User PID of the user you want to read" kind:"attribute" mode:"normal" readonly:"false" name:"user-pid"`
+	UserEmail              string `description:"Auth0 User email of the user you want to read" kind:"attribute" mode:"normal" readonly:"false" name:"user-email"`
+	Query                  string `description:"Auth0 v3 user search query used to select the users you want to read" kind:"attribute" mode:"normal" readonly:"false" name:"query"`
+	ReadMode               string `description:"How users are read: auto, page or export" kind:"attribute" mode:"normal" readonly:"false" name:"read-mode"`
+	IncludeRBAC            bool   `description:"Read the roles and permissions assigned to each user" kind:"attribute" mode:"normal" readonly:"false" name:"include-rbac"`
+	PermissionsAudience    string `description:"Auth0 API audience used to filter the permissions read for each user" kind:"attribute" mode:"normal" readonly:"false" name:"permissions-audience"`
+	AppMetadata            string `description:"How app_metadata is mapped to applications: none, whole or per-application" kind:"attribute" mode:"normal" readonly:"false" name:"app-metadata"`
+	AppMetadataApp         string `description:"Application the whole app_metadata is mapped to" kind:"attribute" mode:"normal" readonly:"false" name:"app-metadata-application"`
+	AssignRoles            bool   `description:"Assign the roles of the imported users in Auth0" kind:"attribute" mode:"normal" readonly:"false" name:"assign-roles"`
+	CreateMissingRoles     bool   `description:"Create the assigned roles that do not exist in Auth0" kind:"attribute" mode:"normal" readonly:"false" name:"create-missing-roles"`
+	MaxJobUsers            int    `description:"Maximum number of users imported by a single Auth0 import job, unlimited when 0" kind:"attribute" mode:"normal" readonly:"false" name:"max-job-users"`
+	JobTimeout             int    `description:"Maximum number of seconds to wait for Auth0 jobs to complete, 30 minutes when 0" kind:"attribute" mode:"normal" readonly:"false" name:"job-timeout"`
+	PasswordHashProperty   string `description:"User attribute property holding the Auth0 custom_password_hash of the imported users" kind:"attribute" mode:"normal" readonly:"false" name:"password-hash-property"`
+	MetadataIdentities     bool   `description:"Read the phone number and username of users that have none in their Auth0 profile from the user_metadata" kind:"attribute" mode:"normal" readonly:"false" name:"metadata-identities"`
+	MappingFile            string `description:"JSON or YAML file declaring how user fields are mapped between Auth0 and Aserto" kind:"attribute" mode:"normal" readonly:"false" name:"mapping-file"`
+	UpdatedSince           string `description:"RFC3339 timestamp of the last sync; only the users updated after it are read" kind:"attribute" mode:"normal" readonly:"false" name:"updated-since"`
+	ReadConcurrency        int    `description:"Number of pages of users read concurrently ahead of time, 4 when 0" kind:"attribute" mode:"normal" readonly:"false" name:"read-concurrency"`
+	PageSize               int    `description:"Number of users read per page, up to 100, 50 when 0" kind:"attribute" mode:"normal" readonly:"false" name:"page-size"`
+	DryRun                 bool   `description:"Plan the changes of writes and deletes without making them" kind:"attribute" mode:"normal" readonly:"false" name:"dry-run"`
+	Reconcile              string `description:"What happens to the users of the connection missing from the written users: none, delete or block" kind:"attribute" mode:"normal" readonly:"false" name:"reconcile"`
+	MaxDeletions           int    `description:"Maximum number of users reconcile may delete or block, 100 when 0; none are removed when more are missing" kind:"attribute" mode:"normal" readonly:"false" name:"max-deletions"`
+	Offline                bool   `description:"Only validate the configuration, without resolving secret references nor connecting to Auth0" kind:"attribute" mode:"normal" readonly:"false" name:"offline"`
+	Filter                 string `description:"Expression selecting the users read and written, evaluated against the Auth0 user on reads and the Aserto user on writes" kind:"attribute" mode:"normal" readonly:"false" name:"filter"`
 }
 
+// Validate validates the configuration for the operation, connecting to
+// Auth0 to check the credentials unless the configuration is offline.
 func (c *Auth0Config) Validate(operation plugin.OperationType) error {
+	if err := c.ValidateStructure(operation); err != nil {
+		return err
+	}
 
+	if c.Offline {
+		return nil
+	}
+
+	return c.ValidateConnectivity(operation)
+}
+
+// ValidateStructure validates the configuration for the operation without
+// any network access nor resolving secret references: the settings, their
+// combinations and the mapping file.
+func (c *Auth0Config) ValidateStructure(operation plugin.OperationType) error {
 	if c.Domain == "" {
 		return status.Error(codes.InvalidArgument, "no domain was provided")
 	}
 
-	if c.ClientID == "" {
-		return status.Error(codes.InvalidArgument, "no client id was provided")
+	if err := validateDomain(c.Domain); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid domain %q; expected a host name like tenant.auth0.com", c.Domain)
 	}
 
-	if c.ClientSecret == "" {
-		return status.Error(codes.InvalidArgument, "no client secret was provided")
+	if err := c.validateCredentials(); err != nil {
+		return err
 	}
 
+	validators := []func() error{
+		c.validateReadSelection,
+		c.validateReadMode,
+		c.validateMappings,
+		c.validateWriteOptions,
+		c.validateReconcile,
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateReadSelection validates the settings selecting the users read.
+func (c *Auth0Config) validateReadSelection() error {
 	if c.UserPID != "" && c.UserEmail != "" {
 		return status.Error(codes.InvalidArgument, "an user PID and an user email were provided; please specify only one")
 	}
 
+	if c.Query != "" {
+		if c.UserPID != "" || c.UserEmail != "" {
+			return status.Error(codes.InvalidArgument, "a query can not be combined with an user PID or an user email")
+		}
+
+		if err := validateQuery(c.Query); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid query: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateReadMode validates how users are read.
+func (c *Auth0Config) validateReadMode() error {
+	switch c.ReadMode {
+	case "":
+		c.ReadMode = ReadModeAuto
+	case ReadModeAuto, ReadModePage:
+	case ReadModeExport:
+		if c.UserPID != "" || c.UserEmail != "" || c.Query != "" {
+			return status.Error(codes.InvalidArgument, "the export read mode can not be combined with an user PID, an user email or a query")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid read mode %q; expected one of %s, %s or %s", c.ReadMode, ReadModeAuto, ReadModePage, ReadModeExport)
+	}
+
+	if c.UpdatedSince != "" {
+		if c.UserPID != "" || c.UserEmail != "" {
+			return status.Error(codes.InvalidArgument, "an updated-since timestamp can not be combined with an user PID or an user email")
+		}
+
+		if c.ReadMode == ReadModeExport {
+			return status.Error(codes.InvalidArgument, "the export read mode can not be combined with an updated-since timestamp")
+		}
+
+		if _, err := time.Parse(time.RFC3339, c.UpdatedSince); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid updated-since timestamp %q; expected an RFC3339 timestamp", c.UpdatedSince)
+		}
+	}
+
+	if c.ReadConcurrency < 0 || c.ReadConcurrency > maxReadConcurrency {
+		return status.Errorf(codes.InvalidArgument, "the read concurrency must be between 0 and %d", maxReadConcurrency)
+	}
+
+	if c.PageSize < 0 || c.PageSize > maxPageSize {
+		return status.Errorf(codes.InvalidArgument, "the page size must be between 0 and %d", maxPageSize)
+	}
+
+	return nil
+}
+
+// validateMappings validates how users are mapped between Auth0 and
+// Aserto, and filtered.
+func (c *Auth0Config) validateMappings() error {
+	if c.PermissionsAudience != "" && !c.IncludeRBAC {
+		return status.Error(codes.InvalidArgument, "a permissions audience was provided without enabling include-rbac")
+	}
+
+	switch c.AppMetadata {
+	case "":
+		c.AppMetadata = AppMetadataNone
+	case AppMetadataNone, AppMetadataPerApplication:
+	case AppMetadataWhole:
+		if c.AppMetadataApp == "" {
+			return status.Error(codes.InvalidArgument, "no application was provided to map the whole app_metadata to")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid app_metadata mapping %q; expected one of %s, %s or %s", c.AppMetadata, AppMetadataNone, AppMetadataWhole, AppMetadataPerApplication)
+	}
+
+	if c.MappingFile != "" {
+		if _, err := transform.LoadMapping(c.MappingFile); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid mapping file: %s", err.Error())
+		}
+	}
+
+	if c.Filter != "" {
+		if _, err := filter.Compile(c.Filter); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid filter: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateWriteOptions validates the settings of the import jobs and the
+// role assignments.
+func (c *Auth0Config) validateWriteOptions() error {
+	if c.CreateMissingRoles && !c.AssignRoles {
+		return status.Error(codes.InvalidArgument, "create-missing-roles was enabled without enabling assign-roles")
+	}
+
+	if c.MaxJobUsers < 0 {
+		return status.Error(codes.InvalidArgument, "the maximum number of users per import job can not be negative")
+	}
+
+	if c.JobTimeout < 0 {
+		return status.Error(codes.InvalidArgument, "the job timeout can not be negative")
+	}
+
 	if c.ConnectionName == "" {
 		c.ConnectionName = "Username-Password-Authentication"
 	}
 
-	mgnt, err := management.New(
-		c.Domain,
-		management.WithClientCredentials(
-			c.ClientID,
-			c.ClientSecret,
-		))
+	return nil
+}
+
+// validateReconcile validates the reconcile settings.
+func (c *Auth0Config) validateReconcile() error {
+	switch c.Reconcile {
+	case "":
+		c.Reconcile = ReconcileNone
+	case ReconcileNone, ReconcileDelete, ReconcileBlock:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid reconcile mode %q; expected one of %s, %s or %s", c.Reconcile, ReconcileNone, ReconcileDelete, ReconcileBlock)
+	}
+
+	if c.MaxDeletions < 0 {
+		return status.Error(codes.InvalidArgument, "the maximum number of deletions can not be negative")
+	}
+
+	return nil
+}
+
+// ValidateConnectivity resolves the secret references of the configuration,
+// validates the secrets and checks that they grant access to Auth0 with the
+// scopes the operation needs. It expects a configuration that passed
+// ValidateStructure.
+func (c *Auth0Config) ValidateConnectivity(operation plugin.OperationType) error {
+	if err := c.ResolveSecrets(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if c.usesClientAssertion() {
+		if _, err := c.clientAssertionKey(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid client assertion key: %s", err.Error())
+		}
+	}
+
+	if c.AccessToken != "" {
+		if err := c.checkAccessToken(c.AccessToken, operation, time.Now()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	tokenSource, err := c.tokenSource()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to connect to Auth0, %s", err.Error())
+	}
+
+	if c.AccessToken == "" {
+		// the scopes are checked before any request, which would fail on the
+		// first missing one; the token is reused by the connection lookup
+		token, err := tokenSource.Token()
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to get an Auth0 access token, %s", err.Error())
+		}
+
+		if err := c.checkAccessToken(token.AccessToken, operation, time.Now()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	mgnt, err := newManagement(c.Domain, tokenSource, ratelimit.NewTransport(nil))
 
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to connect to Auth0, %s", err.Error())
@@ -70,6 +341,141 @@ func (c *Auth0Config) Validate(operation plugin.OperationType) error {
 	return nil
 }
 
+// validateCredentials validates the credentials the management client
+// authenticates with.
+func (c *Auth0Config) validateCredentials() error {
+	if c.AccessToken != "" {
+		if c.ClientSecret != "" || c.usesClientAssertion() {
+			return status.Error(codes.InvalidArgument, "an access token and client credentials were provided; please specify only one")
+		}
+
+		if c.Audience != "" || c.TokenEndpoint != "" {
+			return status.Error(codes.InvalidArgument, "an audience or a token endpoint can not be combined with an access token")
+		}
+
+		return nil
+	}
+
+	if c.ClientID == "" {
+		return status.Error(codes.InvalidArgument, "no client id was provided")
+	}
+
+	if c.ClientSecret == "" && !c.usesClientAssertion() {
+		return status.Error(codes.InvalidArgument, "no client secret was provided")
+	}
+
+	if c.TokenEndpoint != "" {
+		u, err := url.Parse(c.TokenEndpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return status.Errorf(codes.InvalidArgument, "invalid token endpoint %q; expected an https URL", c.TokenEndpoint)
+		}
+	}
+
+	return c.validateClientAssertion()
+}
+
+// validateClientAssertion validates the private_key_jwt client assertion
+// settings.
+func (c *Auth0Config) validateClientAssertion() error {
+	if !c.usesClientAssertion() {
+		if c.ClientAssertionKeyID != "" || c.ClientAssertionAlg != "" {
+			return status.Error(codes.InvalidArgument, "a client assertion key id or signing algorithm was provided without a client assertion key")
+		}
+		return nil
+	}
+
+	if c.ClientSecret != "" {
+		return status.Error(codes.InvalidArgument, "a client secret and a client assertion key were provided; please specify only one")
+	}
+
+	if c.ClientAssertionKey != "" && c.ClientAssertionKeyFile != "" {
+		return status.Error(codes.InvalidArgument, "a client assertion key and a client assertion key file were provided; please specify only one")
+	}
+
+	switch c.clientAssertionAlg() {
+	case ClientAssertionRS256, ClientAssertionRS384, ClientAssertionPS256:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid client assertion signing algorithm %q; expected one of %s, %s or %s", c.ClientAssertionAlg, ClientAssertionRS256, ClientAssertionRS384, ClientAssertionPS256)
+	}
+
+	return nil
+}
+
+// validateDomain checks that a domain is a host name, optionally with a port
+// or a scheme, which the management client ignores.
+func validateDomain(domain string) error {
+	if i := strings.Index(domain, "//"); i != -1 {
+		domain = domain[i+2:]
+	}
+	domain = strings.TrimSuffix(domain, "/")
+
+	u, err := url.Parse("https://" + domain)
+	if err != nil {
+		return err
+	}
+	if u.Host != domain || u.Hostname() == "" || strings.ContainsAny(domain, " \t") {
+		return errors.New("not a host name")
+	}
+
+	return nil
+}
+
+// NewManagement returns an Auth0 management client for the configured tenant,
+// sending its requests through the given transport.
+func (c *Auth0Config) NewManagement(transport http.RoundTripper) (*management.Management, error) {
+	tokenSource, err := c.tokenSource()
+	if err != nil {
+		return nil, err
+	}
+
+	return newManagement(c.Domain, tokenSource, transport)
+}
+
+// newManagement returns an Auth0 management client authenticating with the
+// tokens of tokenSource.
+func newManagement(domain string, tokenSource oauth2.TokenSource, transport http.RoundTripper) (*management.Management, error) {
+	// the management client can only use a static token or request one with
+	// a client secret from the tenant itself, so tokens are set by the
+	// transport instead, replacing an empty static one
+	client := &http.Client{Transport: &oauth2.Transport{Source: tokenSource, Base: transport}}
+
+	return management.New(domain, management.WithStaticToken(""), management.WithClient(client))
+}
+
+// tokenSource returns the source of the access tokens of the management
+// client.
+func (c *Auth0Config) tokenSource() (oauth2.TokenSource, error) {
+	if c.AccessToken != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.AccessToken}), nil
+	}
+
+	if c.usesClientAssertion() {
+		key, err := c.clientAssertionKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid client assertion key: %w", err)
+		}
+
+		return oauth2.ReuseTokenSource(nil, &assertionTokenSource{
+			ctx:      context.Background(),
+			tokenURL: c.tokenURL(),
+			audience: c.audience(),
+			issuer:   c.tokenIssuer(),
+			clientID: c.ClientID,
+			key:      key,
+			keyID:    c.ClientAssertionKeyID,
+			alg:      c.clientAssertionAlg(),
+		}), nil
+	}
+
+	cfg := &clientcredentials.Config{
+		ClientID:       c.ClientID,
+		ClientSecret:   c.ClientSecret,
+		TokenURL:       c.tokenURL(),
+		EndpointParams: url.Values{"audience": {c.audience()}},
+	}
+	return cfg.TokenSource(context.Background()), nil
+}
+
 func (c *Auth0Config) Description() string {
 	return "Auth0 plugin"
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
@@ -59,8 +61,9 @@ func TestValidateWithInvalidCredentials(t *testing.T) {
 
 	err := config.Validate(plugin.OperationTypeWrite)
 
+	// the token is requested before the connection is looked up
 	assert.NotNil(err)
-	assert.Contains(err.Error(), "Internal desc = failed to get Auth0 connection")
+	assert.Contains(err.Error(), "Internal desc = failed to get an Auth0 access token")
 }
 
 func TestValidateWithUserIDAndEmail(t *testing.T) {
@@ -79,6 +82,357 @@ func TestValidateWithUserIDAndEmail(t *testing.T) {
 	assert.Contains(err.Error(), "rpc error: code = InvalidArgument desc = an user PID and an user email were provided; please specify only one")
 }
 
+func TestValidateWithQueryAndUserEmail(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		UserEmail:    "test@email.com",
+		Query:        "email_verified:true",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = a query can not be combined with an user PID or an user email", err.Error())
+}
+
+func TestValidateWithInvalidQuery(t *testing.T) {
+	assert := require.New(t)
+	queries := map[string]string{
+		"   ":                        "query is empty",
+		"()":                         "query is empty",
+		`email:"test@email.com`:      "unbalanced quotes",
+		"(email_verified:true":       "unbalanced parentheses",
+		"logins_count:[1 TO 5":       "unbalanced range brackets",
+		"email_verified:true AND":    "query ends with operator AND",
+		"AND email_verified:true":    "unexpected AND at position 1",
+		"email: AND name:test":       "missing value for field email",
+		"name:test AND email:()":     "missing value for field email",
+		"name:test OR OR name:other": "unexpected OR at position 3",
+	}
+
+	for query, expected := range queries {
+		config := Auth0Config{
+			Domain:       "domain",
+			ClientID:     "id",
+			ClientSecret: "secret",
+			Query:        query,
+		}
+
+		err := config.Validate(plugin.OperationTypeRead)
+
+		assert.NotNil(err, query)
+		assert.Equal("rpc error: code = InvalidArgument desc = invalid query: "+expected, err.Error(), query)
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	assert := require.New(t)
+	queries := []string{
+		`app_metadata.tenant:"acme" AND email_verified:true`,
+		`(name:"jane smith" OR name:john) AND NOT blocked:true`,
+		"logins_count:[100 TO 200}",
+		`email:*@acme.com`,
+		`name:jane\ smith`,
+		`email:("a@x.com" OR "b@x.com")`,
+		`email_verified:true AND NOT app_metadata.tenant:(acme OR contoso)`,
+	}
+
+	for _, query := range queries {
+		assert.NoError(validateQuery(query), query)
+	}
+}
+
+func TestValidateWithInvalidReadMode(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		ReadMode:     "stream",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid read mode "stream"; expected one of auto, page or export`, err.Error())
+}
+
+func TestValidateWithExportReadModeAndQuery(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		ReadMode:     ReadModeExport,
+		Query:        "email_verified:true",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the export read mode can not be combined with an user PID, an user email or a query", err.Error())
+}
+
+func TestValidateWithPermissionsAudienceWithoutRBAC(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:              "domain",
+		ClientID:            "id",
+		ClientSecret:        "secret",
+		PermissionsAudience: "https://api.acme.com",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = a permissions audience was provided without enabling include-rbac", err.Error())
+}
+
+func TestValidateWithInvalidAppMetadataMapping(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		AppMetadata:  "partial",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid app_metadata mapping "partial"; expected one of none, whole or per-application`, err.Error())
+}
+
+func TestValidateWithWholeAppMetadataWithoutApplication(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		AppMetadata:  AppMetadataWhole,
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = no application was provided to map the whole app_metadata to", err.Error())
+}
+
+func TestValidateWithCreateMissingRolesWithoutAssignRoles(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:             "domain",
+		ClientID:           "id",
+		ClientSecret:       "secret",
+		CreateMissingRoles: true,
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = create-missing-roles was enabled without enabling assign-roles", err.Error())
+}
+
+func TestValidateWithNegativeMaxJobUsers(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		MaxJobUsers:  -1,
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the maximum number of users per import job can not be negative", err.Error())
+}
+
+func TestValidateWithNegativeJobTimeout(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		JobTimeout:   -1,
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the job timeout can not be negative", err.Error())
+}
+
+func TestValidateWithInvalidUpdatedSince(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		UpdatedSince: "2022-01-10",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid updated-since timestamp "2022-01-10"; expected an RFC3339 timestamp`, err.Error())
+}
+
+func TestValidateWithUpdatedSinceAndExportReadMode(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		ReadMode:     ReadModeExport,
+		UpdatedSince: "2022-01-10T09:00:00Z",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the export read mode can not be combined with an updated-since timestamp", err.Error())
+}
+
+func TestValidateWithInvalidPageSize(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		PageSize:     101,
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the page size must be between 0 and 100", err.Error())
+}
+
+func TestValidateWithNegativeReadConcurrency(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:          "domain",
+		ClientID:        "id",
+		ClientSecret:    "secret",
+		ReadConcurrency: -1,
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the read concurrency must be between 0 and 20", err.Error())
+}
+
+func TestValidateWithInvalidMappingFile(t *testing.T) {
+	assert := require.New(t)
+	mappingFile := filepath.Join(t.TempDir(), "mapping.yaml")
+	assert.NoError(os.WriteFile(mappingFile, []byte("to_aserto: [{source: name, target: id}]"), 0o600))
+
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		MappingFile:  mappingFile,
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid mapping file: to_aserto[0]: unsupported target "id"`, err.Error())
+}
+
+func TestValidateWithInvalidFilter(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Filter:       "user.email ==",
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "rpc error: code = InvalidArgument desc = invalid filter: ")
+}
+
+func TestValidateWithInvalidReconcileMode(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "domain",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Reconcile:    "mirror",
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid reconcile mode "mirror"; expected one of none, delete or block`, err.Error())
+}
+
+func TestValidateOffline(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "https://tenant.invalid/",
+		ClientID:     "id",
+		ClientSecret: "env:AUTH0_TEST_MISSING",
+		AssignRoles:  true,
+		Offline:      true,
+	}
+
+	assert.NoError(config.Validate(plugin.OperationTypeWrite))
+	assert.Equal("env:AUTH0_TEST_MISSING", config.ClientSecret, "secret references should not be resolved offline")
+
+	config.CreateMissingRoles, config.AssignRoles = true, false
+	err := config.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = create-missing-roles was enabled without enabling assign-roles", err.Error())
+}
+
+func TestValidateStructureWithInvalidDomain(t *testing.T) {
+	assert := require.New(t)
+
+	for _, domain := range []string{"tenant.auth0.com/api/v2", "tenant auth0.com", "https://", "tenant.auth0.com?x=1"} {
+		config := Auth0Config{
+			Domain:       domain,
+			ClientID:     "id",
+			ClientSecret: "secret",
+		}
+
+		err := config.ValidateStructure(plugin.OperationTypeRead)
+		assert.Error(err, domain)
+		assert.Equal(`rpc error: code = InvalidArgument desc = invalid domain "`+domain+`"; expected a host name like tenant.auth0.com`, err.Error())
+	}
+
+	for _, domain := range []string{"tenant.auth0.com", "https://tenant.eu.auth0.com/", "127.0.0.1:8443"} {
+		config := Auth0Config{
+			Domain:       domain,
+			ClientID:     "id",
+			ClientSecret: "secret",
+		}
+		assert.NoError(config.ValidateStructure(plugin.OperationTypeRead), domain)
+	}
+}
+
+func TestValidateConnectivity(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:       "tenant.invalid",
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}
+	assert.NoError(config.ValidateStructure(plugin.OperationTypeRead))
+
+	err := config.ValidateConnectivity(plugin.OperationTypeRead)
+	assert.Error(err)
+	assert.Contains(err.Error(), "rpc error: code = Internal desc = failed to get an Auth0 access token")
+}
+
 func TestDescription(t *testing.T) {
 	assert := require.New(t)
 	config := Auth0Config{
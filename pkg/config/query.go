@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateQuery performs a lightweight syntax check of an Auth0 v3 user search
+// query, so obviously broken queries are rejected before an import starts.
+// It does not attempt to fully parse the Lucene syntax, Auth0 remains the
+// authority on what is accepted.
+func validateQuery(query string) error {
+	if strings.TrimSpace(query) == "" {
+		return fmt.Errorf("query is empty")
+	}
+
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return fmt.Errorf("query is empty")
+	}
+
+	prevOperator := true
+	for i, token := range tokens {
+		switch {
+		case isBinaryOperator(token):
+			if prevOperator {
+				return fmt.Errorf("unexpected %s at position %d", token, i+1)
+			}
+			prevOperator = true
+		case token == "NOT":
+			prevOperator = true
+		default:
+			prevOperator = false
+		}
+	}
+
+	if prevOperator {
+		return fmt.Errorf("query ends with operator %s", tokens[len(tokens)-1])
+	}
+
+	return nil
+}
+
+// group is a parenthesized group of a query, and the field it holds the
+// values of for field groups.
+type group struct {
+	field string
+	start int
+}
+
+// queryTokenizer holds the state of tokenizeQuery.
+type queryTokenizer struct {
+	tokens   []string
+	current  strings.Builder
+	ranges   []rune
+	groups   []group
+	inQuotes bool
+	escaped  bool
+}
+
+// tokenizeQuery splits the query into whitespace separated terms, while
+// checking that quotes, parentheses and range brackets are balanced.
+func tokenizeQuery(query string) ([]string, error) {
+	t := &queryTokenizer{}
+
+	for _, r := range query {
+		if err := t.next(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.finish()
+}
+
+func (t *queryTokenizer) flush() {
+	if t.current.Len() > 0 {
+		t.tokens = append(t.tokens, t.current.String())
+		t.current.Reset()
+	}
+}
+
+func (t *queryTokenizer) next(r rune) error {
+	if t.escaped {
+		t.current.WriteRune(r)
+		t.escaped = false
+		return nil
+	}
+
+	switch {
+	case r == '\\':
+		t.escaped = true
+		t.current.WriteRune(r)
+	case r == '"':
+		t.inQuotes = !t.inQuotes
+		t.current.WriteRune(r)
+	case t.inQuotes:
+		t.current.WriteRune(r)
+	case r == '(':
+		t.openGroup()
+	case r == ')':
+		return t.closeGroup()
+	case r == '[' || r == '{':
+		t.ranges = append(t.ranges, r)
+		t.current.WriteRune(r)
+	case r == ']' || r == '}':
+		if len(t.ranges) == 0 {
+			return fmt.Errorf("unbalanced range brackets")
+		}
+		t.ranges = t.ranges[:len(t.ranges)-1]
+		t.current.WriteRune(r)
+	case len(t.ranges) > 0:
+		// range expressions such as [1 TO 5] contain whitespace and the
+		// TO keyword, keep them as a single term
+		t.current.WriteRune(r)
+	case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+		t.flush()
+	default:
+		t.current.WriteRune(r)
+	}
+
+	return nil
+}
+
+func (t *queryTokenizer) openGroup() {
+	// a field group such as email:("a" OR "b") holds the values of the
+	// field, the field itself is not a term
+	field := ""
+	if strings.HasSuffix(t.current.String(), ":") {
+		field = strings.TrimSuffix(t.current.String(), ":")
+		t.current.Reset()
+	}
+	t.flush()
+	t.groups = append(t.groups, group{field: field, start: len(t.tokens)})
+}
+
+func (t *queryTokenizer) closeGroup() error {
+	t.flush()
+	if len(t.groups) == 0 {
+		return fmt.Errorf("unbalanced parentheses")
+	}
+	g := t.groups[len(t.groups)-1]
+	t.groups = t.groups[:len(t.groups)-1]
+	if g.field != "" && len(t.tokens) == g.start {
+		return fmt.Errorf("missing value for field %s", g.field)
+	}
+	return nil
+}
+
+func (t *queryTokenizer) finish() ([]string, error) {
+	if t.escaped {
+		return nil, fmt.Errorf("query ends with an escape character")
+	}
+
+	if t.inQuotes {
+		return nil, fmt.Errorf("unbalanced quotes")
+	}
+
+	if len(t.groups) != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+
+	if len(t.ranges) != 0 {
+		return nil, fmt.Errorf("unbalanced range brackets")
+	}
+
+	t.flush()
+
+	for _, token := range t.tokens {
+		if strings.HasSuffix(token, ":") {
+			return nil, fmt.Errorf("missing value for field %s", strings.TrimSuffix(token, ":"))
+		}
+	}
+
+	return t.tokens, nil
+}
+
+func isBinaryOperator(token string) bool {
+	return token == "AND" || token == "OR" || token == "&&" || token == "||"
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SecretResolver resolves references to secrets held in a secret store.
+type SecretResolver interface {
+	// Resolve returns the secret a reference points to, the reference being
+	// what follows the scheme the resolver is registered for. Errors must not
+	// include the secret.
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver.
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	secretResolversMu sync.RWMutex                 // nolint:gochecknoglobals // guards secretResolvers
+	secretResolvers   = map[string]SecretResolver{ // nolint:gochecknoglobals // registry
+		"env":  SecretResolverFunc(resolveEnvSecret),
+		"file": SecretResolverFunc(resolveFileSecret),
+	}
+)
+
+// RegisterSecretResolver registers the resolver of the secret references
+// starting with scheme followed by a colon, like vault:secret/auth0. The env
+// and file schemes are registered by default, and can be replaced.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+
+	if resolver == nil {
+		delete(secretResolvers, scheme)
+		return
+	}
+	secretResolvers[scheme] = resolver
+}
+
+// resolveSecret returns the secret a value refers to. Values that do not
+// start with the scheme of a registered resolver are returned as is.
+func resolveSecret(value string) (string, error) {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return value, nil
+	}
+	scheme, ref := value[:i], value[i+1:]
+
+	secretResolversMu.RLock()
+	resolver, ok := secretResolvers[scheme]
+	secretResolversMu.RUnlock()
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := resolver.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret reference: %w", scheme, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%s secret reference resolved to an empty secret", scheme)
+	}
+
+	return secret, nil
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return secret, nil
+}
+
+func resolveFileSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ResolveSecrets replaces the secret references of the configuration with
+// the secrets they point to. Resolved secrets are not references anymore,
+// so they are only resolved once.
+func (c *Auth0Config) ResolveSecrets() error {
+	secrets := []struct {
+		name  string
+		value *string
+	}{
+		{"client-secret", &c.ClientSecret},
+		{"client-assertion-key", &c.ClientAssertionKey},
+		{"access-token", &c.AccessToken},
+	}
+
+	for _, secret := range secrets {
+		resolved, err := resolveSecret(*secret.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", secret.name, err)
+		}
+		*secret.value = resolved
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("AUTH0_TEST_SECRET", "env-secret")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(os.WriteFile(secretFile, []byte("file-token\n"), 0o600))
+
+	config := Auth0Config{
+		ClientSecret:       "env:AUTH0_TEST_SECRET",
+		AccessToken:        "file:" + secretFile,
+		ClientAssertionKey: "plain-value",
+	}
+	assert.NoError(config.ResolveSecrets())
+	assert.Equal("env-secret", config.ClientSecret)
+	assert.Equal("file-token", config.AccessToken)
+	assert.Equal("plain-value", config.ClientAssertionKey)
+
+	// resolved secrets are not resolved again
+	t.Setenv("AUTH0_TEST_SECRET", "other-secret")
+	assert.NoError(config.ResolveSecrets())
+	assert.Equal("env-secret", config.ClientSecret)
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("AUTH0_TEST_EMPTY", "")
+
+	secrets := map[string]string{
+		"invalid client-secret: failed to resolve env secret reference: environment variable AUTH0_TEST_MISSING is not set": "env:AUTH0_TEST_MISSING",
+		"invalid client-secret: env secret reference resolved to an empty secret":                                           "env:AUTH0_TEST_EMPTY",
+	}
+
+	for expected, secret := range secrets {
+		config := Auth0Config{ClientSecret: secret}
+		err := config.ResolveSecrets()
+		assert.Error(err)
+		assert.Equal(expected, err.Error())
+	}
+
+	config := Auth0Config{ClientSecret: "file:" + filepath.Join(t.TempDir(), "missing")}
+	err := config.ResolveSecrets()
+	assert.Error(err)
+	assert.Contains(err.Error(), "invalid client-secret: failed to resolve file secret reference: open ")
+}
+
+func TestRegisterSecretResolver(t *testing.T) {
+	assert := require.New(t)
+
+	RegisterSecretResolver("vault", SecretResolverFunc(func(ref string) (string, error) {
+		if ref == "secret/auth0" {
+			return "vault-secret", nil
+		}
+		return "", errors.New("secret not found")
+	}))
+	t.Cleanup(func() { RegisterSecretResolver("vault", nil) })
+
+	config := Auth0Config{ClientSecret: "vault:secret/auth0"}
+	assert.NoError(config.ResolveSecrets())
+	assert.Equal("vault-secret", config.ClientSecret)
+
+	config = Auth0Config{ClientSecret: "vault:secret/other"}
+	err := config.ResolveSecrets()
+	assert.Error(err)
+	assert.Equal("invalid client-secret: failed to resolve vault secret reference: secret not found", err.Error())
+
+	RegisterSecretResolver("vault", nil)
+	config = Auth0Config{ClientSecret: "vault:secret/auth0"}
+	assert.NoError(config.ResolveSecrets())
+	assert.Equal("vault:secret/auth0", config.ClientSecret, "unregistered schemes should not be resolved")
+}
+
+func TestValidateWithSecretReference(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
+	t.Setenv("AUTH0_TEST_SECRET", "env-secret")
+	config := Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "env:AUTH0_TEST_SECRET",
+	}
+	assert.NoError(config.Validate(plugin.OperationTypeWrite))
+	assert.Equal("env-secret", fake.TokenRequests()[0].Get("client_secret"))
+
+	config.ClientSecret = "env:AUTH0_TEST_MISSING"
+	err := config.Validate(plugin.OperationTypeRead)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid client-secret: failed to resolve env secret reference: environment variable AUTH0_TEST_MISSING is not set", err.Error())
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+)
+
+// accessTokenClaims are the claims of a Management API access token checked
+// before starting an operation.
+type accessTokenClaims struct {
+	Expiry int64  `json:"exp"`
+	Scope  string `json:"scope"`
+}
+
+// parseAccessToken decodes the claims of a Management API access token. The
+// signature is not verified, the token is only inspected to fail early when
+// Auth0 would reject it.
+func parseAccessToken(token string) (*accessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a JWT")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+
+	claims := &accessTokenClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+// missingScopes returns the required scopes that are not granted by the
+// token, sorted.
+func (t *accessTokenClaims) missingScopes(required []string) []string {
+	granted := make(map[string]bool)
+	for _, scope := range strings.Fields(t.Scope) {
+		granted[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// checkAccessToken checks that an access token has not expired and grants
+// the scopes required by the operation.
+func (c *Auth0Config) checkAccessToken(token string, operation plugin.OperationType, now time.Time) error {
+	claims, err := parseAccessToken(token)
+	if err != nil {
+		return fmt.Errorf("invalid access token: %w", err)
+	}
+
+	if claims.Expiry != 0 && !now.Before(time.Unix(claims.Expiry, 0)) {
+		return fmt.Errorf("the access token expired at %s", time.Unix(claims.Expiry, 0).UTC().Format(time.RFC3339))
+	}
+
+	if missing := claims.missingScopes(c.requiredScopes(operation)); len(missing) > 0 {
+		return fmt.Errorf("the access token is missing the required scopes %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// requiredScopes returns the Management API scopes the operation needs with
+// the configured features. Users are only read by reads, dry runs, role
+// assignments resolving users by email and reconcile; import jobs are
+// followed with create:users.
+func (c *Auth0Config) requiredScopes(operation plugin.OperationType) []string {
+	scopes := make(map[string]bool)
+
+	switch operation {
+	case plugin.OperationTypeRead:
+		scopes["read:users"] = true
+		if c.IncludeRBAC {
+			scopes["read:roles"] = true
+		}
+	case plugin.OperationTypeWrite:
+		scopes["read:connections"] = true
+		if c.DryRun || c.AssignRoles || c.Reconcile == ReconcileDelete || c.Reconcile == ReconcileBlock {
+			scopes["read:users"] = true
+		}
+		if !c.DryRun {
+			// import jobs upsert users with create:users alone
+			scopes["create:users"] = true
+		}
+		if c.AssignRoles {
+			scopes["read:roles"] = true
+			if !c.DryRun {
+				scopes["create:role_members"] = true
+			}
+		}
+		if c.CreateMissingRoles && !c.DryRun {
+			scopes["create:roles"] = true
+		}
+		if c.Reconcile == ReconcileDelete && !c.DryRun {
+			scopes["delete:users"] = true
+		}
+		if c.Reconcile == ReconcileBlock && !c.DryRun {
+			scopes["update:users"] = true
+		}
+	case plugin.OperationTypeDelete:
+		if c.DryRun {
+			scopes["read:users"] = true
+		} else {
+			scopes["delete:users"] = true
+		}
+	}
+
+	required := make([]string, 0, len(scopes))
+	for scope := range scopes {
+		required = append(required, scope)
+	}
+	sort.Strings(required)
+
+	return required
+}
+
+// tokenURL returns the URL access tokens are requested from.
+func (c *Auth0Config) tokenURL() string {
+	if c.TokenEndpoint != "" {
+		return c.TokenEndpoint
+	}
+	return tenantURL(c.Domain) + "oauth/token"
+}
+
+// audience returns the audience of the requested access tokens.
+func (c *Auth0Config) audience() string {
+	if c.Audience != "" {
+		return c.Audience
+	}
+	return tenantURL(c.Domain) + "api/v2/"
+}
+
+// tokenIssuer returns the URL of the tenant issuing the access tokens, which
+// client assertions are addressed to.
+func (c *Auth0Config) tokenIssuer() string {
+	u, err := url.Parse(c.tokenURL())
+	if err != nil || u.Host == "" {
+		return tenantURL(c.Domain)
+	}
+	return u.Scheme + "://" + u.Host + "/"
+}
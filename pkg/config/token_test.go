@@ -0,0 +1,206 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/ratelimit"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+// createAccessToken returns an unsigned JWT with the given claims.
+func createAccessToken(t *testing.T, claims map[string]interface{}) string {
+	data, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(data) + ".signature"
+}
+
+func TestValidateWithAccessToken(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
+	token := createAccessToken(t, map[string]interface{}{
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read:users read:connections create:users update:users",
+	})
+	config := Auth0Config{
+		Domain:      fake.Domain(),
+		AccessToken: token,
+	}
+	assert.NoError(config.Validate(plugin.OperationTypeWrite))
+
+	mgmt, err := config.NewManagement(ratelimit.NewTransport(nil))
+	assert.NoError(err)
+	_, err = mgmt.User.Read("auth0|2ff319e101e1")
+	assert.NoError(err)
+	assert.Equal(token, fake.Bearer())
+	assert.Empty(fake.TokenRequests())
+}
+
+func TestValidateWithExpiredAccessToken(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain: "domain",
+		AccessToken: createAccessToken(t, map[string]interface{}{
+			"exp":   time.Date(2022, time.January, 10, 9, 0, 0, 0, time.UTC).Unix(),
+			"scope": "read:users",
+		}),
+	}
+
+	err := config.Validate(plugin.OperationTypeRead)
+
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token expired at 2022-01-10T09:00:00Z", err.Error())
+}
+
+func TestValidateWithAccessTokenMissingScopes(t *testing.T) {
+	assert := require.New(t)
+	config := Auth0Config{
+		Domain:      "domain",
+		AssignRoles: true,
+		AccessToken: createAccessToken(t, map[string]interface{}{
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "read:users create:users",
+		}),
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes create:role_members, read:connections, read:roles", err.Error())
+}
+
+func TestValidateWithInvalidAccessToken(t *testing.T) {
+	assert := require.New(t)
+
+	configs := map[string]Auth0Config{
+		"invalid access token: not a JWT": {
+			AccessToken: "secret-token",
+		},
+		"an access token and client credentials were provided; please specify only one": {
+			AccessToken: "token", ClientID: "id", ClientSecret: "secret",
+		},
+		"an audience or a token endpoint can not be combined with an access token": {
+			AccessToken: "token", Audience: "https://tenant.auth0.com/api/v2/",
+		},
+		`invalid token endpoint "http://tenant.auth0.com/oauth/token"; expected an https URL`: {
+			ClientID: "id", ClientSecret: "secret", TokenEndpoint: "http://tenant.auth0.com/oauth/token",
+		},
+	}
+
+	for expected, config := range configs {
+		config.Domain = "domain"
+
+		err := config.Validate(plugin.OperationTypeRead)
+		assert.Error(err, expected)
+		assert.Equal("rpc error: code = InvalidArgument desc = "+expected, err.Error())
+		assert.NotContains(err.Error(), "secret-token")
+	}
+}
+
+func TestNewManagementWithAudienceAndTokenEndpoint(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
+	config := Auth0Config{
+		Domain:        fake.Domain(),
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		Audience:      "https://tenant.auth0.com/api/v2/",
+		TokenEndpoint: "https://" + fake.Domain() + "/oauth/token",
+	}
+
+	mgmt, err := config.NewManagement(ratelimit.NewTransport(nil))
+	assert.NoError(err)
+
+	_, err = mgmt.User.Read("auth0|2ff319e101e1")
+	assert.NoError(err)
+
+	requests := fake.TokenRequests()
+	assert.Equal(1, len(requests))
+	assert.Equal("https://tenant.auth0.com/api/v2/", requests[0].Get("audience"))
+}
+
+func TestRequiredScopes(t *testing.T) {
+	assert := require.New(t)
+
+	config := Auth0Config{}
+	assert.Equal([]string{"read:users"}, config.requiredScopes(plugin.OperationTypeRead))
+	assert.Equal([]string{"delete:users"}, config.requiredScopes(plugin.OperationTypeDelete))
+	assert.Equal([]string{"create:users", "read:connections"}, config.requiredScopes(plugin.OperationTypeWrite))
+
+	config = Auth0Config{IncludeRBAC: true}
+	assert.Equal([]string{"read:roles", "read:users"}, config.requiredScopes(plugin.OperationTypeRead))
+
+	config = Auth0Config{DryRun: true, AssignRoles: true, CreateMissingRoles: true, Reconcile: ReconcileDelete}
+	assert.Equal([]string{"read:connections", "read:roles", "read:users"}, config.requiredScopes(plugin.OperationTypeWrite))
+	assert.Equal([]string{"read:users"}, config.requiredScopes(plugin.OperationTypeDelete))
+
+	config = Auth0Config{Reconcile: ReconcileBlock}
+	assert.Equal([]string{"create:users", "read:connections", "read:users", "update:users"}, config.requiredScopes(plugin.OperationTypeWrite))
+
+	config = Auth0Config{AssignRoles: true, CreateMissingRoles: true, Reconcile: ReconcileDelete}
+	assert.Equal([]string{"create:role_members", "create:roles", "create:users", "delete:users", "read:connections", "read:roles", "read:users"},
+		config.requiredScopes(plugin.OperationTypeWrite))
+}
+
+func TestValidateScopes(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+	fake.SetScopes("read:users", "read:connections", "create:users")
+
+	config := Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+		AssignRoles:  true,
+	}
+	assert.NoError(config.Validate(plugin.OperationTypeRead))
+
+	err := config.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes create:role_members, read:roles", err.Error())
+
+	config.DryRun = true
+	err = config.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes read:roles", err.Error(), "dry runs should only require read scopes")
+
+	err = config.Validate(plugin.OperationTypeDelete)
+	assert.NoError(err)
+
+	config.DryRun = false
+	err = config.Validate(plugin.OperationTypeDelete)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes delete:users", err.Error())
+
+	// deletes do not read users
+	fake.SetScopes("delete:users")
+	assert.NoError(config.Validate(plugin.OperationTypeDelete))
+
+	// the scopes are checked before the connection is looked up
+	fake.SetScopes("read:users", "create:users")
+	config = Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}
+	err = config.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes read:connections", err.Error())
+
+	// imports only need create:users
+	fake.SetScopes("read:connections", "create:users")
+	assert.NoError(config.Validate(plugin.OperationTypeWrite))
+
+	config.Reconcile = ReconcileBlock
+	err = config.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = the access token is missing the required scopes read:users, update:users", err.Error())
+}
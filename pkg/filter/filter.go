@@ -0,0 +1,141 @@
+// Package filter selects the users read and written by the plugin using
+// expressions that Auth0 search queries can not express.
+//
+// Expressions use the github.com/antonmedv/expr language and must evaluate to
+// a boolean. The user is available as the user variable: on reads it holds
+// the Auth0 user, using the field names of the Management API (user.email,
+// user.logins_count, user.app_metadata.plan), on writes it holds the Aserto
+// user (user.email, user.display_name, user.properties.department,
+// user.roles). The domain function returns the domain of an email address.
+//
+//	domain(user.email) in ["acme.com", "acme.org"]
+//	user.logins_count > 0
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"gopkg.in/auth0.v5/management"
+)
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	program *vm.Program
+}
+
+// Compile compiles a filter expression.
+func Compile(expression string) (*Filter, error) {
+	program, err := expr.Compile(expression, expr.Env(env(nil)), expr.AsBool())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{program: program}, nil
+}
+
+// MatchAuth0 returns true if the Auth0 user matches the filter.
+func (f *Filter) MatchAuth0(user *management.User) (bool, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return false, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false, err
+	}
+
+	return f.match(fields)
+}
+
+// MatchUser returns true if the Aserto user matches the filter.
+func (f *Filter) MatchUser(user *api.User) (bool, error) {
+	return f.match(userFields(user))
+}
+
+func (f *Filter) match(fields map[string]interface{}) (bool, error) {
+	out, err := expr.Run(f.program, env(fields))
+	if err != nil {
+		return false, err
+	}
+
+	match, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter returned %T instead of a boolean", out)
+	}
+	return match, nil
+}
+
+func env(user map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"user":   user,
+		"domain": domain,
+	}
+}
+
+// domain returns the domain of an email address, lower cased.
+func domain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(email[i+1:])
+}
+
+// userFields returns the fields of an Aserto user available to filters.
+func userFields(user *api.User) map[string]interface{} {
+	identities := make(map[string]interface{}, len(user.Identities))
+	for key, identity := range user.Identities {
+		identities[key] = map[string]interface{}{
+			"kind":     strings.ToLower(strings.TrimPrefix(identity.GetKind().String(), "IDENTITY_KIND_")),
+			"provider": identity.GetProvider(),
+			"verified": identity.GetVerified(),
+		}
+	}
+
+	applications := make(map[string]interface{}, len(user.Applications))
+	for name, app := range user.Applications {
+		applications[name] = attrSetFields(app)
+	}
+
+	fields := attrSetFields(user.Attributes)
+	fields["id"] = user.Id
+	fields["display_name"] = user.DisplayName
+	fields["email"] = user.Email
+	fields["picture"] = user.Picture
+	fields["enabled"] = user.Enabled == nil || *user.Enabled
+	fields["deleted"] = user.Deleted
+	fields["identities"] = identities
+	fields["applications"] = applications
+
+	return fields
+}
+
+func attrSetFields(attrs *api.AttrSet) map[string]interface{} {
+	fields := map[string]interface{}{
+		"properties":  map[string]interface{}{},
+		"roles":       []string{},
+		"permissions": []string{},
+	}
+
+	if attrs == nil {
+		return fields
+	}
+
+	if attrs.Properties != nil {
+		fields["properties"] = attrs.Properties.AsMap()
+	}
+	if attrs.Roles != nil {
+		fields["roles"] = attrs.Roles
+	}
+	if attrs.Permissions != nil {
+		fields["permissions"] = attrs.Permissions
+	}
+
+	return fields
+}
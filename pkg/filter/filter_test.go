@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestMatchAuth0(t *testing.T) {
+	assert := require.New(t)
+
+	user := auth0TestUtils.CreateTestAuth0User("1", "April Stewart", "April@Acme.com", "pic", "+40722332233", "april")
+	logins := int64(3)
+	user.LoginsCount = &logins
+	user.AppMetadata = map[string]interface{}{"plan": "enterprise"}
+
+	expressions := map[string]bool{
+		`domain(user.email) == "acme.com"`:                    true,
+		`domain(user.email) in ["test.com", "example.com"]`:   false,
+		`user.logins_count > 2`:                               true,
+		`user.app_metadata.plan == "enterprise"`:              true,
+		`user.user_metadata.phoneNumber startsWith "+40"`:     true,
+		`user.username == "april" && user.blocked == nil`:     true,
+		`user.app_metadata.plan == "free" || user.name == ""`: false,
+	}
+
+	for expression, expected := range expressions {
+		f, err := Compile(expression)
+		assert.NoError(err, expression)
+
+		match, err := f.MatchAuth0(user)
+		assert.NoError(err, expression)
+		assert.Equal(expected, match, expression)
+	}
+}
+
+func TestMatchUser(t *testing.T) {
+	assert := require.New(t)
+
+	user := auth0TestUtils.CreateTestAPIUser("1", "April Stewart", "april@acme.com", "pic")
+	user.Attributes.Roles = []string{"admin"}
+	user.Attributes.Properties.Fields["department"] = structpb.NewStringValue("Sales")
+	user.Identities["auth0|1"] = &api.IdentitySource{Kind: api.IdentityKind_IDENTITY_KIND_PID, Provider: "auth0", Verified: true}
+
+	expressions := map[string]bool{
+		`domain(user.email) == "acme.com"`:         true,
+		`"admin" in user.roles`:                    true,
+		`user.properties.department == "Sales"`:    true,
+		`user.display_name matches "^April"`:       true,
+		`user.enabled && !user.deleted`:            true,
+		`len(user.permissions) > 0`:                false,
+		`"portal" in user.applications`:            false,
+		`user.identities["auth0|1"].kind == "pid"`: true,
+		`user.properties.department == "Admins"`:   false,
+	}
+
+	for expression, expected := range expressions {
+		f, err := Compile(expression)
+		assert.NoError(err, expression)
+
+		match, err := f.MatchUser(user)
+		assert.NoError(err, expression)
+		assert.Equal(expected, match, expression)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	assert := require.New(t)
+
+	for _, expression := range []string{`user.email ==`, `user.email`, `domain(1)`, `lower(user.email)`} {
+		_, err := Compile(expression)
+		assert.Error(err, expression)
+	}
+}
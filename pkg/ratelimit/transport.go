@@ -0,0 +1,246 @@
+// Package ratelimit provides an HTTP transport that handles the rate limits
+// of the Auth0 Management API.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is the number of times a throttled request is retried.
+	DefaultMaxRetries = 5
+	// DefaultMaxWait is the longest time waited before retrying a request.
+	DefaultMaxWait = time.Minute
+
+	// fallbackWait is the first wait before retrying a throttled request
+	// when the response does not tell when to retry, doubled on every
+	// attempt.
+	fallbackWait = time.Second
+)
+
+// Rate limit response headers.
+const (
+	headerRetryAfter = "Retry-After"
+	headerRemaining  = "X-RateLimit-Remaining"
+	headerReset      = "X-RateLimit-Reset"
+)
+
+// Error is returned instead of a 429 Too Many Requests response when a
+// request is still throttled after the allowed retries, or can not be
+// retried because it is not idempotent.
+type Error struct {
+	Method   string
+	URL      string
+	Attempts int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s %s after %d attempts", e.Method, e.URL, e.Attempts)
+}
+
+// Transport is an http.RoundTripper that follows the Auth0 rate limit
+// headers. Once the rate limit is exhausted, requests wait for it to reset,
+// and throttled idempotent requests are retried after the Retry-After or
+// X-RateLimit-Reset delay.
+//
+// Throttled requests are never returned as 429 responses, so the retries of
+// the Auth0 SDK, which are not bounded, do not kick in.
+type Transport struct {
+	// Base is the transport requests are sent with, http.DefaultTransport
+	// when nil.
+	Base       http.RoundTripper
+	MaxRetries int
+	MaxWait    time.Duration
+
+	throttled int64
+
+	mu         sync.Mutex
+	blockUntil time.Time
+
+	// sleep waits for the given duration, or until the context is done.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewTransport returns a Transport sending requests with base.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:       base,
+		MaxRetries: DefaultMaxRetries,
+		MaxWait:    DefaultMaxWait,
+	}
+}
+
+// Throttled returns the number of requests that were throttled by Auth0 or
+// that waited for the rate limit to reset.
+func (t *Transport) Throttled() int64 {
+	return atomic.LoadInt64(&t.throttled)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		if err := t.waitReset(ctx); err != nil {
+			return nil, err
+		}
+
+		if attempt > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		res, err := t.base().RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t.observe(res)
+
+		if res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+
+		atomic.AddInt64(&t.throttled, 1)
+		wait := t.retryAfter(res, attempt)
+		drain(res)
+
+		if !t.retryable(req) || attempt > t.MaxRetries {
+			return nil, &Error{Method: req.Method, URL: req.URL.String(), Attempts: attempt}
+		}
+
+		if err := t.wait(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// retryable returns true for idempotent requests whose body can be resent.
+func (t *Transport) retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.GetBody != nil
+	default:
+		return false
+	}
+}
+
+// observe records when the rate limit resets once it is exhausted.
+func (t *Transport) observe(res *http.Response) {
+	if res.Header.Get(headerRemaining) != "0" {
+		return
+	}
+
+	reset, ok := parseReset(res.Header.Get(headerReset))
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	if reset.After(t.blockUntil) {
+		t.blockUntil = reset
+	}
+	t.mu.Unlock()
+}
+
+// waitReset waits for the rate limit to reset when it is exhausted.
+func (t *Transport) waitReset(ctx context.Context) error {
+	t.mu.Lock()
+	wait := time.Until(t.blockUntil)
+	t.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&t.throttled, 1)
+	return t.wait(ctx, t.capWait(wait))
+}
+
+// retryAfter returns how long to wait before retrying a throttled request,
+// from the Retry-After or X-RateLimit-Reset headers, or backing off
+// exponentially when neither is set.
+func (t *Transport) retryAfter(res *http.Response, attempt int) time.Duration {
+	if v := res.Header.Get(headerRetryAfter); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return t.capWait(time.Duration(seconds) * time.Second)
+		}
+		if date, err := http.ParseTime(v); err == nil {
+			return t.capWait(time.Until(date))
+		}
+	}
+
+	if reset, ok := parseReset(res.Header.Get(headerReset)); ok {
+		return t.capWait(time.Until(reset))
+	}
+
+	return t.capWait(fallbackWait << (attempt - 1))
+}
+
+func (t *Transport) wait(ctx context.Context, d time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+	return sleep(ctx, d)
+}
+
+func (t *Transport) capWait(wait time.Duration) time.Duration {
+	switch {
+	case wait < 0:
+		return 0
+	case t.MaxWait > 0 && wait > t.MaxWait:
+		return t.MaxWait
+	default:
+		return wait
+	}
+}
+
+// parseReset parses the X-RateLimit-Reset header, the unix time at which the
+// rate limit resets.
+func parseReset(v string) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// drain reads and closes the body of a discarded response, so the connection
+// can be reused.
+func drain(res *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
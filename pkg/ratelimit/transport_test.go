@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTransport returns a transport recording its waits instead of
+// sleeping.
+func newTestTransport() (*Transport, *[]time.Duration) {
+	var waits []time.Duration
+	t := NewTransport(nil)
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return t, &waits
+}
+
+// throttlingServer fails the first throttled requests with 429, setting the
+// given headers.
+func throttlingServer(t *testing.T, throttled int, headers map[string]string) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= throttled {
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert := require.New(t)
+
+	server, requests := throttlingServer(t, 2, map[string]string{"Retry-After": "3"})
+	transport, waits := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	assert.NoError(err)
+	res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(3, *requests)
+	assert.Equal([]time.Duration{3 * time.Second, 3 * time.Second}, *waits)
+	assert.Equal(int64(2), transport.Throttled())
+}
+
+func TestRetryAtReset(t *testing.T) {
+	assert := require.New(t)
+
+	reset := time.Now().Add(10 * time.Second).Unix()
+	server, _ := throttlingServer(t, 1, map[string]string{"X-RateLimit-Reset": strconv.FormatInt(reset, 10)})
+	transport, waits := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	assert.NoError(err)
+	res.Body.Close()
+	assert.Equal(1, len(*waits))
+	assert.InDelta(10*time.Second, (*waits)[0], float64(2*time.Second))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	server, _ := throttlingServer(t, 3, nil)
+	transport, waits := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	assert.NoError(err)
+	res.Body.Close()
+	assert.Equal([]time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *waits)
+}
+
+func TestRetriesAreBounded(t *testing.T) {
+	assert := require.New(t)
+
+	server, requests := throttlingServer(t, 100, map[string]string{"Retry-After": "1"})
+	transport, _ := newTestTransport()
+	transport.MaxRetries = 2
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get(server.URL)
+	assert.Error(err)
+
+	var rateLimitErr *Error
+	assert.True(errors.As(err, &rateLimitErr))
+	assert.Equal(3, rateLimitErr.Attempts)
+	assert.Equal(3, *requests)
+	assert.Contains(err.Error(), "rate limit exceeded for GET")
+}
+
+func TestNonIdempotentRequestsAreNotRetried(t *testing.T) {
+	assert := require.New(t)
+
+	server, requests := throttlingServer(t, 1, map[string]string{"Retry-After": "1"})
+	transport, waits := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	assert.Error(err)
+	assert.Equal(1, *requests)
+	assert.Equal(0, len(*waits))
+	assert.Equal(int64(1), transport.Throttled())
+}
+
+func TestRequestBodyIsResent(t *testing.T) {
+	assert := require.New(t)
+
+	var bodies []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		buf := new(strings.Builder)
+		_, _ = io.Copy(buf, r.Body)
+		bodies = append(bodies, buf.String())
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport, _ := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"name":"x"}`))
+	assert.NoError(err)
+	res, err := client.Do(req)
+	assert.NoError(err)
+	res.Body.Close()
+	assert.Equal([]string{`{"name":"x"}`, `{"name":"x"}`}, bodies)
+}
+
+func TestWaitForExhaustedRateLimit(t *testing.T) {
+	assert := require.New(t)
+
+	reset := time.Now().Add(5 * time.Second).Unix()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport, waits := newTestTransport()
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		assert.NoError(err)
+		res.Body.Close()
+	}
+
+	assert.Equal(1, len(*waits), "should wait for the reset before the second request")
+	assert.InDelta(5*time.Second, (*waits)[0], float64(2*time.Second))
+}
+
+func TestWaitIsCapped(t *testing.T) {
+	assert := require.New(t)
+
+	server, _ := throttlingServer(t, 1, map[string]string{"Retry-After": "3600"})
+	transport, waits := newTestTransport()
+	transport.MaxWait = 10 * time.Second
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	assert.NoError(err)
+	res.Body.Close()
+	assert.Equal([]time.Duration{10 * time.Second}, *waits)
+}
@@ -0,0 +1,53 @@
+package srv
+
+// batch accumulates the users of an import job, keeping track of the size of
+// the JSON array they are uploaded as, so jobs never exceed the Auth0 import
+// file size limit nor the configured maximum number of users.
+type batch struct {
+	maxSize  int64
+	maxUsers int
+	users    []map[string]interface{}
+	size     int64
+}
+
+func newBatch(maxSize int64, maxUsers int) *batch {
+	return &batch{
+		maxSize:  maxSize,
+		maxUsers: maxUsers,
+	}
+}
+
+// sizeWith returns the size of the serialized batch once a user serialized to
+// size bytes is added, including the array brackets and separators.
+func (b *batch) sizeWith(size int64) int64 {
+	if len(b.users) == 0 {
+		return size + 2
+	}
+	return b.size + size + 1
+}
+
+// fits returns true if a user serialized to size bytes can be added to the
+// batch without exceeding its limits.
+func (b *batch) fits(size int64) bool {
+	if b.maxUsers > 0 && len(b.users) >= b.maxUsers {
+		return false
+	}
+	return b.sizeWith(size) <= b.maxSize
+}
+
+func (b *batch) add(user map[string]interface{}, size int64) {
+	b.size = b.sizeWith(size)
+	b.users = append(b.users, user)
+}
+
+func (b *batch) empty() bool {
+	return len(b.users) == 0
+}
+
+// flush returns the users accumulated so far and resets the batch.
+func (b *batch) flush() []map[string]interface{} {
+	users := b.users
+	b.users = nil
+	b.size = 0
+	return users
+}
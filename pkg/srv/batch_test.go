@@ -0,0 +1,149 @@
+package srv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestBatchSize(t *testing.T) {
+	assert := require.New(t)
+	b := newBatch(20, 0)
+
+	assert.True(b.fits(18))
+	assert.False(b.fits(19), "should account for the array brackets")
+
+	b.add(map[string]interface{}{"a": 1}, 8)
+	assert.Equal(int64(10), b.size)
+
+	b.add(map[string]interface{}{"b": 2}, 8)
+	assert.Equal(int64(19), b.size, "should account for the separator")
+	assert.False(b.fits(1))
+
+	users := b.flush()
+	assert.Equal(2, len(users))
+	assert.True(b.empty())
+	assert.Equal(int64(0), b.size)
+}
+
+func TestBatchMaxUsers(t *testing.T) {
+	assert := require.New(t)
+	b := newBatch(maxBatchSize, 2)
+
+	b.add(map[string]interface{}{}, 2)
+	assert.True(b.fits(2))
+	b.add(map[string]interface{}{}, 2)
+	assert.False(b.fits(2))
+}
+
+func TestWriteBatchBoundaries(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
+	cfg := config.Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+		MaxJobUsers:  2,
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		user := auth0TestUtils.CreateTestAPIUser(fmt.Sprintf("%d", i), "Test User", fmt.Sprintf("user%d@test.com", i), "pic")
+		assert.NoError(auth0Plugin.Write(user))
+	}
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(5), stats.Received)
+	assert.Equal(int32(5), stats.Created)
+
+	imports := fake.Imports()
+	assert.Equal(3, len(imports))
+	assert.Equal(2, len(imports[0]))
+	assert.Equal(2, len(imports[1]))
+	assert.Equal(1, len(imports[2]))
+	assert.Equal("4", imports[2][0]["user_id"])
+}
+
+func TestWriteBatchFileSize(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
+	cfg := config.Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	// every user serializes to a bit more than 100KB
+	picture := strings.Repeat("p", 100*1024)
+	for i := 0; i < 10; i++ {
+		user := auth0TestUtils.CreateTestAPIUser(fmt.Sprintf("%d", i), "Test User", fmt.Sprintf("user%d@test.com", i), picture)
+		assert.NoError(auth0Plugin.Write(user))
+	}
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+
+	imports := fake.Imports()
+	assert.Equal(3, len(imports))
+	assert.Equal(4, len(imports[0]))
+	assert.Equal(4, len(imports[1]))
+	assert.Equal(2, len(imports[2]))
+
+	user := auth0TestUtils.CreateTestAPIUser("big", "Test User", "big@test.com", strings.Repeat("p", int(maxBatchSize)))
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	err = auth0Plugin.Write(user)
+	assert.Error(err)
+	assert.Contains(err.Error(), "user big exceeds the maximum import file size")
+}
+
+func TestWritePasswordHash(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.PasswordHashProperty = "password_hash"
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	user := auth0TestUtils.CreateTestAPIUser("1", "Test User", "user1@test.com", "pic")
+	hash, err := structpb.NewValue(map[string]interface{}{
+		"algorithm": "bcrypt",
+		"hash":      map[string]interface{}{"value": "$2b$10$C9hx7yhTYmgjb6ghmmD3zOZWTLh4FCrDpUxPKRY9tcGOJtKzGvmGy"},
+	})
+	assert.NoError(err)
+	user.Attributes.Properties.Fields["password_hash"] = hash
+	assert.NoError(auth0Plugin.Write(user))
+
+	invalid := auth0TestUtils.CreateTestAPIUser("2", "Test User", "user2@test.com", "pic")
+	invalid.Attributes.Properties.Fields["password_hash"] = structpb.NewStringValue(`{"algorithm": "rot13"}`)
+	err = auth0Plugin.Write(invalid)
+	assert.Error(err)
+	assert.Equal(`invalid password hash for user 2: unsupported password hash algorithm "rot13"`, err.Error())
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+
+	imports := fake.Imports()
+	assert.Equal(1, len(imports))
+	assert.Equal(1, len(imports[0]))
+	assert.Equal("bcrypt", imports[0][0]["custom_password_hash"].(map[string]interface{})["algorithm"])
+	assert.Nil(imports[0][0]["user_metadata"])
+}
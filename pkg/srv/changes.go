@@ -0,0 +1,307 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/auth0.v5/management"
+)
+
+// changeFeedTake is the number of log entries read per request, the maximum
+// allowed by the logs endpoint.
+const changeFeedTake = 100
+
+// ChangeType is the kind of change made to a user.
+type ChangeType string
+
+const (
+	ChangeCreate ChangeType = "create"
+	ChangeUpdate ChangeType = "update"
+	ChangeDelete ChangeType = "delete"
+)
+
+// Auth0 log event types of the user lifecycle.
+const (
+	logTypeSuccessSignup       = "ss"
+	logTypeSuccessUserDeletion = "sdu"
+	logTypeDeletedUser         = "du"
+	logTypeSuccessAPIOperation = "sapi"
+)
+
+// Change is a change made to a user, read from the tenant logs.
+type Change struct {
+	Type   ChangeType
+	UserID string
+	LogID  string
+	Date   time.Time
+	// User is the current state of a created or updated user, nil for
+	// deletions.
+	User *api.User
+}
+
+// ChangeFeed reads the user lifecycle changes from the Auth0 tenant logs,
+// resuming from a checkpoint persisted to a checkpoint file. The feed is not
+// reachable through the plugin interface, it is meant for programs embedding
+// the plugin, and needs the read:logs scope.
+//
+// The checkpoint of the changes returned by Next is persisted by the next
+// call to Next or by Close, so changes are delivered at least once even if
+// the process stops while they are being applied.
+type ChangeFeed struct {
+	plugin         *Auth0Plugin
+	checkpointFile string
+	checkpoint     string
+	saved          string
+}
+
+// ChangeFeed returns a feed of the changes made to the users since the
+// checkpoint persisted to checkpointFile. When there is no checkpoint yet,
+// the feed starts after the most recent log entry. An empty checkpointFile
+// does not persist the checkpoint.
+func (s *Auth0Plugin) ChangeFeed(checkpointFile string) (*ChangeFeed, error) {
+	if s.mgmt == nil {
+		return nil, status.Error(codes.Internal, "auth0 management client not initialized")
+	}
+
+	f := &ChangeFeed{
+		plugin:         s,
+		checkpointFile: checkpointFile,
+	}
+
+	checkpoint, err := f.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint == "" {
+		checkpoint, err = f.latestLogID()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		f.saved = checkpoint
+	}
+	f.checkpoint = checkpoint
+
+	return f, nil
+}
+
+// Checkpoint returns the id of the last log entry read.
+func (f *ChangeFeed) Checkpoint() string {
+	return f.checkpoint
+}
+
+// Next persists the checkpoint of the previously returned changes and reads
+// the following ones. It returns io.EOF once all the logs have been read.
+// Batches of logs without user changes return no changes and no error.
+func (f *ChangeFeed) Next() ([]*Change, error) {
+	if err := f.saveCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	opts := []management.RequestOption{
+		management.Parameter("take", strconv.Itoa(changeFeedTake)),
+		management.Context(f.plugin.context()),
+	}
+	if f.checkpoint != "" {
+		opts = append(opts, management.Parameter("from", f.checkpoint))
+	}
+
+	logs, err := f.plugin.mgmt.Log.List(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs: %w", err)
+	}
+
+	if len(logs) == 0 {
+		return nil, io.EOF
+	}
+
+	// the checkpoint only moves past the batch once all its changes are read,
+	// so a failed batch is read again
+	checkpoint := f.checkpoint
+	var changes []*Change
+	for _, l := range logs {
+		checkpoint = l.GetLogID()
+
+		change := changeFromLog(l)
+		if change == nil {
+			continue
+		}
+
+		if change.Type != ChangeDelete {
+			change.User, err = f.readUser(change.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if change.User == nil {
+				// deleted since, the deletion follows in the logs, or
+				// filtered out
+				continue
+			}
+		}
+
+		changes = append(changes, change)
+	}
+	f.checkpoint = checkpoint
+
+	return changes, nil
+}
+
+// Close persists the checkpoint of the changes read.
+func (f *ChangeFeed) Close() error {
+	return f.saveCheckpoint()
+}
+
+// readUser returns the current state of a user, or nil if it no longer
+// exists or does not match the configured filter.
+func (f *ChangeFeed) readUser(id string) (*api.User, error) {
+	u, err := f.plugin.mgmt.User.Read(id, management.Context(f.plugin.context()))
+	if err != nil {
+		var mErr management.Error
+		if errors.As(err, &mErr) && mErr.Status() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read user %s: %w", id, err)
+	}
+
+	return f.plugin.toAPIUser(u)
+}
+
+func (f *ChangeFeed) latestLogID() (string, error) {
+	logs, err := f.plugin.mgmt.Log.List(
+		management.Parameter("sort", "date:-1"),
+		management.PerPage(1),
+		management.Context(f.plugin.context()),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to read logs: %w", err)
+	}
+
+	if len(logs) == 0 {
+		return "", nil
+	}
+	return logs[0].GetLogID(), nil
+}
+
+func (f *ChangeFeed) readCheckpoint() (string, error) {
+	if f.checkpointFile == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(f.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read change feed checkpoint: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint file, so an interrupted
+// write never leaves a truncated checkpoint behind.
+func (f *ChangeFeed) saveCheckpoint() error {
+	if f.checkpointFile == "" || f.checkpoint == f.saved {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.checkpointFile), filepath.Base(f.checkpointFile)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save change feed checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(f.checkpoint + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.checkpointFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save change feed checkpoint: %w", err)
+	}
+
+	f.saved = f.checkpoint
+	return nil
+}
+
+// changeFromLog returns the user change recorded by a log entry, or nil if
+// the entry is not part of the user lifecycle.
+func changeFromLog(l *management.Log) *Change {
+	var changeType ChangeType
+
+	switch l.GetType() {
+	case logTypeSuccessSignup:
+		changeType = ChangeCreate
+	case logTypeSuccessUserDeletion, logTypeDeletedUser:
+		changeType = ChangeDelete
+	case logTypeSuccessAPIOperation:
+		switch l.GetDescription() {
+		case "Create a User":
+			changeType = ChangeCreate
+		case "Update a User":
+			changeType = ChangeUpdate
+		case "Delete a User":
+			changeType = ChangeDelete
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	userID := logUserID(l)
+	if userID == "" {
+		return nil
+	}
+
+	return &Change{
+		Type:   changeType,
+		UserID: userID,
+		LogID:  l.GetLogID(),
+		Date:   l.GetDate(),
+	}
+}
+
+// logUserID returns the id of the user a log entry refers to. Management API
+// operations do not set it, it is then taken from the request path or the
+// response of the operation.
+func logUserID(l *management.Log) string {
+	if id := l.GetUserID(); id != "" {
+		return id
+	}
+
+	if request, ok := l.Details["request"].(map[string]interface{}); ok {
+		if path, ok := request["path"].(string); ok {
+			if i := strings.Index(path, "/users/"); i >= 0 {
+				id := strings.SplitN(path[i+len("/users/"):], "/", 2)[0]
+				if id, err := url.PathUnescape(id); err == nil && id != "" {
+					return id
+				}
+			}
+		}
+	}
+
+	if response, ok := l.Details["response"].(map[string]interface{}); ok {
+		if body, ok := response["body"].(map[string]interface{}); ok {
+			if id, ok := body["user_id"].(string); ok {
+				return id
+			}
+		}
+	}
+
+	return ""
+}
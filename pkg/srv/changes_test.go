@@ -0,0 +1,168 @@
+package srv
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+func readChanges(t *testing.T, feed *ChangeFeed) []*Change {
+	var changes []*Change
+	for {
+		batch, err := feed.Next()
+		if err == io.EOF {
+			return changes
+		}
+		require.NoError(t, err)
+		changes = append(changes, batch...)
+	}
+}
+
+func TestChangeFromLog(t *testing.T) {
+	assert := require.New(t)
+
+	change := changeFromLog(&management.Log{
+		LogID:       auth0.String("1"),
+		Type:        auth0.String("sapi"),
+		Description: auth0.String("Update a User"),
+		Details: map[string]interface{}{
+			"request": map[string]interface{}{"path": "/api/v2/users/auth0%7C6b0dbf0a8f2b"},
+		},
+	})
+	assert.Equal(ChangeUpdate, change.Type)
+	assert.Equal("auth0|6b0dbf0a8f2b", change.UserID)
+
+	change = changeFromLog(&management.Log{
+		LogID:       auth0.String("2"),
+		Type:        auth0.String("sapi"),
+		Description: auth0.String("Create a User"),
+		Details: map[string]interface{}{
+			"request":  map[string]interface{}{"path": "/api/v2/users"},
+			"response": map[string]interface{}{"body": map[string]interface{}{"user_id": "auth0|b3c4e7f3c8a1"}},
+		},
+	})
+	assert.Equal(ChangeCreate, change.Type)
+	assert.Equal("auth0|b3c4e7f3c8a1", change.UserID)
+
+	change = changeFromLog(&management.Log{LogID: auth0.String("3"), Type: auth0.String("sdu"), UserID: auth0.String("auth0|2ff319e101e1")})
+	assert.Equal(ChangeDelete, change.Type)
+
+	assert.Nil(changeFromLog(&management.Log{Type: auth0.String("s"), UserID: auth0.String("auth0|2ff319e101e1")}))
+	assert.Nil(changeFromLog(&management.Log{Type: auth0.String("sapi"), Description: auth0.String("Assign roles to a user")}))
+}
+
+func TestChangeFeed(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint")
+
+	fake.AddLog(map[string]interface{}{"type": "s", "user_id": "auth0|2ff319e101e1"})
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	// without a checkpoint, the feed starts after the latest log entry
+	feed, err := auth0Plugin.ChangeFeed(checkpointFile)
+	assert.NoError(err)
+	assert.Equal(0, len(readChanges(t, feed)))
+	assert.NoError(feed.Close())
+
+	fake.AddLog(map[string]interface{}{"type": "ss", "user_id": "auth0|b3c4e7f3c8a1"})
+	fake.AddLog(map[string]interface{}{"type": "s", "user_id": "auth0|b3c4e7f3c8a1"})
+	fake.AddLog(map[string]interface{}{
+		"type":        "sapi",
+		"description": "Update a User",
+		"details":     map[string]interface{}{"request": map[string]interface{}{"path": "/api/v2/users/auth0%7C6b0dbf0a8f2b"}},
+	})
+	fake.RemoveUser("auth0|2ff319e101e1")
+	last := fake.AddLog(map[string]interface{}{"type": "sdu", "user_id": "auth0|2ff319e101e1"})
+
+	feed, err = auth0Plugin.ChangeFeed(checkpointFile)
+	assert.NoError(err)
+
+	changes := readChanges(t, feed)
+	assert.Equal(3, len(changes))
+	assert.Equal(ChangeCreate, changes[0].Type)
+	assert.Equal("Chris Chavez", changes[0].User.DisplayName)
+	assert.Equal(ChangeUpdate, changes[1].Type)
+	assert.Equal("April Stewart", changes[1].User.DisplayName)
+	assert.Equal(ChangeDelete, changes[2].Type)
+	assert.Equal("auth0|2ff319e101e1", changes[2].UserID)
+	assert.Nil(changes[2].User)
+	assert.Equal(last, feed.Checkpoint())
+	assert.NoError(feed.Close())
+
+	data, err := os.ReadFile(checkpointFile)
+	assert.NoError(err)
+	assert.Equal(last+"\n", string(data))
+
+	// resuming from the checkpoint only reads the new changes
+	fake.AddLog(map[string]interface{}{"type": "du", "user_id": "auth0|b3c4e7f3c8a1"})
+
+	feed, err = auth0Plugin.ChangeFeed(checkpointFile)
+	assert.NoError(err)
+
+	changes = readChanges(t, feed)
+	assert.Equal(1, len(changes))
+	assert.Equal(ChangeDelete, changes[0].Type)
+	assert.Equal("auth0|b3c4e7f3c8a1", changes[0].UserID)
+}
+
+func TestChangeFeedSkipsUsersDeletedSince(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	feed, err := auth0Plugin.ChangeFeed("")
+	assert.NoError(err)
+
+	fake.AddLog(map[string]interface{}{"type": "ss", "user_id": "auth0|gone"})
+	fake.AddLog(map[string]interface{}{"type": "sdu", "user_id": "auth0|gone"})
+
+	changes := readChanges(t, feed)
+	assert.Equal(1, len(changes))
+	assert.Equal(ChangeDelete, changes[0].Type)
+}
+
+func TestChangeFeedErrorKeepsCheckpoint(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint")
+
+	start := fake.AddLog(map[string]interface{}{"type": "s", "user_id": "auth0|2ff319e101e1"})
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	feed, err := auth0Plugin.ChangeFeed(checkpointFile)
+	assert.NoError(err)
+	assert.Equal(start, feed.Checkpoint())
+
+	fake.AddLog(map[string]interface{}{"type": "sdu", "user_id": "auth0|6b0dbf0a8f2b"})
+	fake.AddLog(map[string]interface{}{"type": "ss", "user_id": "auth0|b3c4e7f3c8a1"})
+	fake.FailUser("auth0|b3c4e7f3c8a1")
+
+	// the deletion read before the error is not skipped by the checkpoint
+	_, err = feed.Next()
+	assert.Error(err)
+	assert.Equal(start, feed.Checkpoint())
+	assert.NoError(feed.Close())
+
+	data, err := os.ReadFile(checkpointFile)
+	assert.NoError(err)
+	assert.Equal(start+"\n", string(data))
+}
@@ -0,0 +1,135 @@
+package srv
+
+import (
+	"fmt"
+	"time"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"gopkg.in/auth0.v5/management"
+)
+
+// deltaTimeFormat is the format of the updated_at bounds of delta queries,
+// matching the millisecond precision of the Auth0 timestamps.
+const deltaTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// deltaWindow is the state of an incremental read. Auth0 can only list the
+// first maxPaginatedUsers users of a query, so users are read in windows
+// sorted by updated_at, each starting where the previous one stopped.
+type deltaWindow struct {
+	// start is the lower bound of the current window, exclusive for the
+	// first window and inclusive for the following ones.
+	start     time.Time
+	inclusive bool
+
+	// highWater is the most recent updated_at read so far, and highWaterIDs
+	// are the ids of the users read that were updated at that time.
+	highWater    time.Time
+	highWaterIDs map[string]bool
+}
+
+func newDeltaWindow(since time.Time) *deltaWindow {
+	return &deltaWindow{
+		start:        since,
+		highWater:    since,
+		highWaterIDs: make(map[string]bool),
+	}
+}
+
+// query returns the search query selecting the users of the window.
+func (d *deltaWindow) query(query string) string {
+	open := "{"
+	if d.inclusive {
+		open = "["
+	}
+
+	q := fmt.Sprintf(`updated_at:%s"%s" TO *]`, open, d.start.UTC().Format(deltaTimeFormat))
+	if query != "" {
+		q = fmt.Sprintf("(%s) AND %s", query, q)
+	}
+	return q
+}
+
+// seen returns true if the user was already read in a previous window.
+func (d *deltaWindow) seen(u *management.User) bool {
+	return u.GetUpdatedAt().Equal(d.highWater) && d.highWaterIDs[u.GetID()]
+}
+
+func (d *deltaWindow) advance(u *management.User) {
+	updated := u.GetUpdatedAt()
+
+	switch {
+	case updated.After(d.highWater):
+		d.highWater = updated
+		d.highWaterIDs = map[string]bool{u.GetID(): true}
+	case updated.Equal(d.highWater):
+		d.highWaterIDs[u.GetID()] = true
+	}
+}
+
+// next starts a new window at the high-water mark.
+func (d *deltaWindow) next() error {
+	if !d.highWater.After(d.start) {
+		return fmt.Errorf("more than %d users were updated at %s, they can not be read incrementally", maxPaginatedUsers, d.start.UTC().Format(deltaTimeFormat))
+	}
+
+	d.start = d.highWater
+	d.inclusive = true
+	return nil
+}
+
+// HighWaterMark returns the most recent updated_at of the users read in delta
+// mode, or the configured updated-since timestamp if no user was read. It can
+// be used as the updated-since timestamp of the next run.
+func (s *Auth0Plugin) HighWaterMark() time.Time {
+	if s.delta == nil {
+		return time.Time{}
+	}
+	return s.delta.highWater
+}
+
+// readDelta reads the next page of the users updated since the last sync,
+// sorted by updated_at.
+func (s *Auth0Plugin) readDelta() ([]*api.User, error) {
+	ul, err := s.mgmt.User.List(
+		management.Page(s.page),
+		management.PerPage(s.pageSize()),
+		management.Query(s.delta.query(s.Config.Query)),
+		management.Parameter("sort", "updated_at:1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*api.User
+	for _, u := range ul.Users {
+		if s.delta.seen(u) {
+			continue
+		}
+
+		user, err := s.toAPIUser(u)
+		if err != nil {
+			return nil, err
+		}
+
+		// filtered users still move the high-water mark
+		s.delta.advance(u)
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	s.page++
+
+	if !ul.HasNext() {
+		s.finishedRead = true
+		return users, nil
+	}
+
+	if ul.Start+ul.Limit+ul.Limit > maxPaginatedUsers {
+		if err := s.delta.next(); err != nil {
+			return nil, err
+		}
+		s.page = 0
+	}
+
+	return users, nil
+}
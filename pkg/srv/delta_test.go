@@ -0,0 +1,137 @@
+package srv
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, auth0Plugin *Auth0Plugin) []*api.User {
+	var users []*api.User
+	for {
+		page, err := auth0Plugin.Read()
+		if err == io.EOF {
+			return users
+		}
+		require.NoError(t, err)
+		users = append(users, page...)
+	}
+}
+
+func TestDeltaQuery(t *testing.T) {
+	assert := require.New(t)
+
+	d := newDeltaWindow(time.Date(2022, time.January, 10, 21, 0, 0, 0, time.UTC))
+	assert.Equal(`updated_at:{"2022-01-10T21:00:00.000Z" TO *]`, d.query(""))
+	assert.Equal(`(email_verified:true) AND updated_at:{"2022-01-10T21:00:00.000Z" TO *]`, d.query("email_verified:true"))
+
+	d.inclusive = true
+	assert.Equal(`updated_at:["2022-01-10T21:00:00.000Z" TO *]`, d.query(""))
+}
+
+func TestReadDelta(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.UpdatedSince = "2022-01-10T21:00:00Z"
+	assert.NoError(cfg.Validate(plugin.OperationTypeRead))
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users := readAll(t, auth0Plugin)
+	assert.Equal(2, len(users))
+	assert.Equal("Chris Chavez", users[0].DisplayName)
+	assert.Equal("April Stewart", users[1].DisplayName)
+	assert.Equal(time.Date(2022, time.January, 12, 9, 0, 0, 0, time.UTC), auth0Plugin.HighWaterMark().UTC())
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+
+	// resuming from the high-water mark reads nothing new
+	cfg.UpdatedSince = auth0Plugin.HighWaterMark().Format(time.RFC3339Nano)
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users = readAll(t, auth0Plugin)
+	assert.Equal(0, len(users))
+	assert.Equal(time.Date(2022, time.January, 12, 9, 0, 0, 0, time.UTC), auth0Plugin.HighWaterMark().UTC())
+}
+
+func TestReadDeltaWithQuery(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.UpdatedSince = "2022-01-10T21:00:00Z"
+	cfg.Query = "email_verified:true"
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users := readAll(t, auth0Plugin)
+	assert.Equal(1, len(users))
+	assert.Equal("April Stewart", users[0].DisplayName)
+}
+
+func TestReadDeltaMoreThanListLimit(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.UpdatedSince = "2022-02-01T00:00:00Z"
+
+	// spread the users over fewer timestamps than users, so windows start
+	// in the middle of users updated at the same time
+	since := time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2500; i++ {
+		fake.AddUser(map[string]interface{}{
+			"user_id":    fmt.Sprintf("auth0|delta%04d", i),
+			"email":      fmt.Sprintf("delta%04d@test.com", i),
+			"updated_at": since.Add(time.Duration(i/7+1) * time.Minute).Format(time.RFC3339),
+		})
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users := readAll(t, auth0Plugin)
+	assert.Equal(2500, len(users))
+
+	emails := make(map[string]bool)
+	for _, user := range users {
+		emails[user.Email] = true
+	}
+	assert.Equal(2500, len(emails), "should read every user once")
+	assert.Equal(since.Add(358*time.Minute), auth0Plugin.HighWaterMark().UTC())
+}
+
+func TestReadDeltaTooManyUsersAtOnce(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.UpdatedSince = "2022-02-01T00:00:00Z"
+
+	for i := 0; i < 1100; i++ {
+		fake.AddUser(map[string]interface{}{
+			"user_id":    fmt.Sprintf("auth0|bulk%04d", i),
+			"email":      fmt.Sprintf("bulk%04d@test.com", i),
+			"updated_at": "2022-02-01T10:00:00Z",
+		})
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	for err == nil {
+		_, err = auth0Plugin.Read()
+	}
+	assert.Equal("more than 1000 users were updated at 2022-02-01T10:00:00.000Z, they can not be read incrementally", err.Error())
+}
@@ -0,0 +1,224 @@
+package srv
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+const (
+	// maxPaginatedUsers is the maximum number of users the Auth0 list endpoint
+	// returns, regardless of how many pages are requested.
+	maxPaginatedUsers = 1000
+	exportBatchSize   = 100
+	// exportStallTimeout is how long the download of an export file can wait
+	// for data before it is abandoned.
+	exportStallTimeout = time.Minute
+)
+
+// exportFields are the user fields requested from an export job, they cover
+// everything transform.Transform reads.
+var exportFields = []string{ // nolint:gochecknoglobals // read only
+	"user_id",
+	"email",
+	"email_verified",
+	"username",
+	"nickname",
+	"name",
+	"given_name",
+	"family_name",
+	"picture",
+	"phone_number",
+	"phone_verified",
+	"created_at",
+	"updated_at",
+	"last_login",
+	"logins_count",
+	"blocked",
+	"identities",
+	"user_metadata",
+	"app_metadata",
+}
+
+// exportReader streams users out of the gzipped NDJSON file produced by an
+// Auth0 users-exports job.
+type exportReader struct {
+	body    io.ReadCloser
+	gz      *gzip.Reader
+	decoder *json.Decoder
+}
+
+func newExportReader(body io.ReadCloser) (*exportReader, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to decompress export file: %w", err)
+	}
+
+	return &exportReader{
+		body:    body,
+		gz:      gz,
+		decoder: json.NewDecoder(gz),
+	}, nil
+}
+
+// next returns up to n users, or io.EOF once the export file is exhausted.
+func (r *exportReader) next(n int) ([]*management.User, error) {
+	var users []*management.User
+
+	for len(users) < n {
+		user := &management.User{}
+		err := r.decoder.Decode(user)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode exported user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if len(users) == 0 {
+		return nil, io.EOF
+	}
+
+	return users, nil
+}
+
+func (r *exportReader) Close() error {
+	gzErr := r.gz.Close()
+	err := r.body.Close()
+	if err != nil {
+		return err
+	}
+	return gzErr
+}
+
+// startExport exports all the users of the tenant and opens the resulting
+// file for reading.
+func (s *Auth0Plugin) startExport() error {
+	export, err := s.exportUsers("")
+	if err != nil {
+		return err
+	}
+
+	s.export = export
+	return nil
+}
+
+// exportUsers creates a users-exports job, of a single connection when
+// connectionID is set, waits for it to complete and opens the resulting file
+// for reading.
+func (s *Auth0Plugin) exportUsers(connectionID string) (*exportReader, error) {
+	fields := make([]map[string]interface{}, 0, len(exportFields))
+	for _, field := range exportFields {
+		fields = append(fields, map[string]interface{}{"name": field})
+	}
+
+	job := &management.Job{
+		Format: auth0.String("json"),
+		Fields: fields,
+	}
+	if connectionID != "" {
+		job.ConnectionID = auth0.String(connectionID)
+	}
+
+	err := s.mgmt.Job.ExportUsers(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export job: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(s.context(), s.jobTimeout())
+	defer cancel()
+
+	j, err := s.waitJob(ctx, job.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	location := j.GetLocation()
+	if location == "" {
+		return nil, fmt.Errorf("export job %s did not return a file location", j.GetID())
+	}
+
+	// the file is read as the users are, so the download is bound to the
+	// operation rather than to the job timeout, and only abandoned once it
+	// stalls
+	downloadCtx, cancelDownload := context.WithCancel(s.context())
+	req, err := http.NewRequestWithContext(downloadCtx, http.MethodGet, location, nil)
+	if err != nil {
+		cancelDownload()
+		return nil, fmt.Errorf("invalid export file location: %w", err)
+	}
+
+	timer := time.AfterFunc(exportStallTimeout, cancelDownload)
+	res, err := http.DefaultClient.Do(req) // nolint:gosec // location is returned by Auth0
+	timer.Stop()
+	if err != nil {
+		cancelDownload()
+		return nil, fmt.Errorf("failed to download export file: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		cancelDownload()
+		return nil, fmt.Errorf("failed to download export file, status code: %d", res.StatusCode)
+	}
+
+	return newExportReader(&stallReader{body: res.Body, timeout: exportStallTimeout, cancel: cancelDownload})
+}
+
+// stallReader cancels the download of an export file when a read waits for
+// data longer than the timeout. The time spent between reads, while the
+// users are processed, does not count.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(r.timeout, r.cancel)
+	defer timer.Stop()
+
+	return r.body.Read(p)
+}
+
+func (r *stallReader) Close() error {
+	defer r.cancel()
+	return r.body.Close()
+}
+
+func (s *Auth0Plugin) readExport() ([]*api.User, error) {
+	auth0Users, err := s.export.next(exportBatchSize)
+	if errors.Is(err, io.EOF) {
+		s.finishedRead = true
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*api.User, 0, len(auth0Users))
+	for _, u := range auth0Users {
+		user, err := s.toAPIUser(u)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
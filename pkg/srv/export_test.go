@@ -0,0 +1,87 @@
+package srv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func createExportFile(t *testing.T, count int) io.ReadCloser {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+
+	for i := 0; i < count; i++ {
+		user := auth0TestUtils.CreateTestAuth0User("auth0|"+string(rune('a'+i)), "Name", "user@test.com", "pic", "+40722332233", "userName")
+		require.NoError(t, encoder.Encode(user))
+	}
+	require.NoError(t, gz.Close())
+
+	return io.NopCloser(&buf)
+}
+
+func TestExportReader(t *testing.T) {
+	assert := require.New(t)
+
+	reader, err := newExportReader(createExportFile(t, 3))
+	assert.NoError(err)
+
+	users, err := reader.next(2)
+	assert.NoError(err)
+	assert.Equal(2, len(users))
+	assert.Equal("auth0|a", users[0].GetID())
+	assert.Equal("auth0|b", users[1].GetID())
+
+	users, err = reader.next(2)
+	assert.NoError(err)
+	assert.Equal(1, len(users))
+	assert.Equal("auth0|c", users[0].GetID())
+	assert.Equal("user@test.com", users[0].GetEmail())
+
+	_, err = reader.next(2)
+	assert.Equal(io.EOF, err)
+
+	assert.NoError(reader.Close())
+}
+
+func TestExportReaderInvalidFile(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := newExportReader(io.NopCloser(bytes.NewBufferString("not gzip")))
+	assert.Error(err)
+}
+
+func TestStallReader(t *testing.T) {
+	assert := require.New(t)
+
+	body, w := io.Pipe()
+	canceled := errors.New("download canceled")
+	r := &stallReader{
+		body:    body,
+		timeout: 10 * time.Millisecond,
+		cancel:  func() { w.CloseWithError(canceled) },
+	}
+
+	go func() {
+		_, _ = w.Write([]byte("data"))
+	}()
+
+	buf := make([]byte, 4)
+	n, err := r.Read(buf)
+	assert.NoError(err)
+	assert.Equal("data", string(buf[:n]))
+
+	// waiting between reads does not cancel the download
+	time.Sleep(20 * time.Millisecond)
+
+	// a read waiting for data past the timeout does
+	_, err = r.Read(buf)
+	assert.Equal(canceled, err)
+}
@@ -0,0 +1,116 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/auth0.v5/management"
+)
+
+// ImportError describes why a user could not be imported by an Auth0 import
+// job. User is the id of the imported user, or its email when it was
+// imported without an id.
+type ImportError struct {
+	JobID   string
+	User    string
+	Code    string
+	Message string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("failed to import user %s: %s: %s", e.User, e.Code, e.Message)
+}
+
+// jobErrorRow is a row of the response returned by the jobs/{id}/errors
+// endpoint.
+type jobErrorRow struct {
+	User   map[string]interface{} `json:"user"`
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Path    string `json:"path"`
+	} `json:"errors"`
+}
+
+// importErrors returns an ImportError for every error reported by an import
+// job, mapped back to the users submitted with the job.
+func (s *Auth0Plugin) importErrors(job *management.Job) ([]*ImportError, error) {
+	jobID := job.GetID()
+
+	rows, err := retrieveJobErrors(s.mgmt, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get errors of job %s: %w", jobID, err)
+	}
+
+	submitted := make(map[string]string)
+	for _, user := range job.Users {
+		identifier := userIdentifier(user)
+		if id, ok := user["user_id"].(string); ok && id != "" {
+			submitted["user_id:"+id] = identifier
+		}
+		if email, ok := user["email"].(string); ok && email != "" {
+			submitted["email:"+email] = identifier
+		}
+	}
+
+	var importErrs []*ImportError
+	for _, row := range rows {
+		identifier := userIdentifier(row.User)
+		if id, ok := row.User["user_id"].(string); ok && submitted["user_id:"+id] != "" {
+			identifier = submitted["user_id:"+id]
+		} else if email, ok := row.User["email"].(string); ok && submitted["email:"+email] != "" {
+			identifier = submitted["email:"+email]
+		}
+
+		for _, e := range row.Errors {
+			importErrs = append(importErrs, &ImportError{
+				JobID:   jobID,
+				User:    identifier,
+				Code:    e.Code,
+				Message: e.Message,
+			})
+		}
+	}
+
+	return importErrs, nil
+}
+
+// userIdentifier returns the id of a user submitted to an import job, or its
+// email when it has no id.
+func userIdentifier(user map[string]interface{}) string {
+	if id, ok := user["user_id"].(string); ok && id != "" {
+		return id
+	}
+	email, _ := user["email"].(string)
+	return email
+}
+
+func retrieveJobErrors(mngmt *management.Management, jobID string) ([]jobErrorRow, error) {
+	req, err := mngmt.NewRequest("GET", mngmt.URI("jobs", jobID, "errors"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := mngmt.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("request failed, status code: %d", res.StatusCode)
+	}
+
+	if res.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var rows []jobErrorRow
+	err = json.NewDecoder(res.Body).Decode(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("decoding response payload failed: %w", err)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,93 @@
+package srv
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+func TestImportErrors(t *testing.T) {
+	assert := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/api/v2/jobs/job_1/errors", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[
+			{"user": {"user_id": "2", "email": "two@test.com"}, "errors": [{"code": "INVALID_FORMAT", "message": "Error in picture property", "path": "picture"}]},
+			{"user": {"email": "three@test.com"}, "errors": [{"code": "DUPLICATED_USER", "message": "User already exists"}]}
+		]`))
+	}))
+	defer server.Close()
+
+	mgmt, err := management.New(server.Listener.Addr().String(), management.WithInsecure())
+	assert.NoError(err)
+
+	auth0Plugin := NewAuth0Plugin()
+	auth0Plugin.mgmt = mgmt
+
+	job := &management.Job{
+		ID: auth0.String("job_1"),
+		Users: []map[string]interface{}{
+			{"user_id": "1", "email": "one@test.com"},
+			{"user_id": "2", "email": "two@test.com"},
+			{"email": "three@test.com"},
+		},
+	}
+
+	importErrs, err := auth0Plugin.importErrors(job)
+	assert.NoError(err)
+	assert.Equal(2, len(importErrs))
+	assert.Equal(&ImportError{JobID: "job_1", User: "2", Code: "INVALID_FORMAT", Message: "Error in picture property"}, importErrs[0])
+	assert.Equal(&ImportError{JobID: "job_1", User: "three@test.com", Code: "DUPLICATED_USER", Message: "User already exists"}, importErrs[1])
+	assert.Equal("failed to import user 2: INVALID_FORMAT: Error in picture property", importErrs[0].Error())
+}
+
+func TestImportErrorsNoContent(t *testing.T) {
+	assert := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mgmt, err := management.New(server.Listener.Addr().String(), management.WithInsecure())
+	assert.NoError(err)
+
+	auth0Plugin := NewAuth0Plugin()
+	auth0Plugin.mgmt = mgmt
+
+	importErrs, err := auth0Plugin.importErrors(&management.Job{ID: auth0.String("job_1")})
+	assert.NoError(err)
+	assert.Empty(importErrs)
+}
+
+func TestWriteImportErrors(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("1", "Valid User", "valid@test.com", "pic")))
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2", "Invalid User", "invalid", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(1), stats.Errors)
+
+	var importErr *ImportError
+	assert.True(errors.As(err, &importErr))
+	assert.Equal("2", importErr.User)
+	assert.Equal("INVALID_FORMAT", importErr.Code)
+}
@@ -0,0 +1,139 @@
+package srv
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/auth0.v5/management"
+)
+
+const (
+	defaultJobTimeout      = 30 * time.Minute
+	defaultPollInterval    = 500 * time.Millisecond
+	defaultMaxPollInterval = 30 * time.Second
+)
+
+// Auth0 job statuses.
+const (
+	jobStatusPending    = "pending"
+	jobStatusProcessing = "processing"
+	jobStatusCompleted  = "completed"
+	jobStatusFailed     = "failed"
+)
+
+// backoff computes the intervals between job polls, doubling them up to a
+// maximum and adding jitter so concurrent polls do not happen in lockstep.
+type backoff struct {
+	interval    time.Duration
+	maxInterval time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	interval := b.interval
+
+	b.interval *= 2
+	if b.interval > b.maxInterval {
+		b.interval = b.maxInterval
+	}
+
+	// wait between half and the full interval
+	half := int64(interval / 2)
+	if half <= 0 {
+		return interval
+	}
+	return time.Duration(half + rand.Int63n(half+1)) // nolint:gosec // jitter does not need a secure source
+}
+
+type jobResult struct {
+	job *management.Job
+	err error
+}
+
+// jobTimeout returns the maximum time to wait for jobs to complete.
+func (s *Auth0Plugin) jobTimeout() time.Duration {
+	if s.Config.JobTimeout > 0 {
+		return time.Duration(s.Config.JobTimeout) * time.Second
+	}
+	return defaultJobTimeout
+}
+
+func (s *Auth0Plugin) newBackoff() *backoff {
+	b := &backoff{
+		interval:    s.pollInterval,
+		maxInterval: s.maxPollInterval,
+	}
+	if b.interval <= 0 {
+		b.interval = defaultPollInterval
+	}
+	if b.maxInterval <= 0 {
+		b.maxInterval = defaultMaxPollInterval
+	}
+	return b
+}
+
+// waitJob polls a job until it completes, fails or the context is done.
+func (s *Auth0Plugin) waitJob(ctx context.Context, jobID string) (*management.Job, error) {
+	b := s.newBackoff()
+
+	for {
+		j, err := s.readJob(ctx, jobID)
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("stopped waiting for job %s: %w", jobID, ctx.Err())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job %s: %w", jobID, err)
+		}
+
+		switch j.GetStatus() {
+		case jobStatusPending, jobStatusProcessing:
+		case jobStatusCompleted:
+			return j, nil
+		case jobStatusFailed:
+			return nil, fmt.Errorf("job %s failed", jobID)
+		default:
+			return nil, fmt.Errorf("job %s has unknown status %q", jobID, j.GetStatus())
+		}
+
+		timer := time.NewTimer(b.next())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("stopped waiting for job %s: %w", jobID, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// waitJobs waits for all the submitted jobs concurrently, returning their
+// results in submission order.
+func (s *Auth0Plugin) waitJobs() []jobResult {
+	ctx, cancel := context.WithTimeout(s.context(), s.jobTimeout())
+	defer cancel()
+
+	results := make([]jobResult, len(s.jobs))
+
+	var wg sync.WaitGroup
+	for i := range s.jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].job, results[i].err = s.waitJob(ctx, s.jobs[i].GetID())
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// readJob reads a job honoring the context, which JobManager.Read does not.
+func (s *Auth0Plugin) readJob(ctx context.Context, jobID string) (*management.Job, error) {
+	var j *management.Job
+	err := s.mgmt.Request("GET", s.mgmt.URI("jobs", jobID), &j, management.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
@@ -0,0 +1,84 @@
+package srv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	assert := require.New(t)
+	b := &backoff{interval: 100 * time.Millisecond, maxInterval: 300 * time.Millisecond}
+
+	for _, max := range []time.Duration{100, 200, 300, 300} {
+		interval := b.next()
+		assert.GreaterOrEqual(interval, max*time.Millisecond/2)
+		assert.LessOrEqual(interval, max*time.Millisecond)
+	}
+}
+
+func TestWaitJobsConcurrently(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+	fake.SetJobPolls(4)
+	fake.SetJobPollDelay(20 * time.Millisecond)
+
+	cfg := config.Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+		MaxJobUsers:  1,
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	auth0Plugin.pollInterval = 10 * time.Millisecond
+	auth0Plugin.maxPollInterval = 20 * time.Millisecond
+
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		user := auth0TestUtils.CreateTestAPIUser(fmt.Sprintf("%d", i), "Test User", fmt.Sprintf("user%d@test.com", i), "pic")
+		assert.NoError(auth0Plugin.Write(user))
+	}
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(3), stats.Received)
+	assert.Equal(int32(3), stats.Created)
+	// the jobs are polled concurrently, not one after the other
+	assert.Greater(fake.MaxConcurrentJobPolls(), 1)
+}
+
+func TestWaitJobsTimeout(t *testing.T) {
+	assert := require.New(t)
+	fake := auth0TestUtils.NewFakeAuth0(t)
+	fake.SetJobPolls(1000)
+
+	cfg := config.Auth0Config{
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+		JobTimeout:   1,
+	}
+
+	auth0Plugin := NewAuth0Plugin()
+	auth0Plugin.pollInterval = 10 * time.Millisecond
+	auth0Plugin.maxPollInterval = 50 * time.Millisecond
+
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	user := auth0TestUtils.CreateTestAPIUser("1", "Test User", "user@test.com", "pic")
+	assert.NoError(auth0Plugin.Write(user))
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "stopped waiting for job job_1")
+	assert.Equal(int32(0), stats.Received)
+}
@@ -0,0 +1,252 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	multierror "github.com/hashicorp/go-multierror"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+// Plan holds the changes a dry run would have made to the Auth0 tenant.
+// Users are identified by their user id, or by their email when they are
+// imported without one.
+type Plan struct {
+	// Create and Update are the users the import jobs would create or update.
+	Create []string
+	Update []string
+	// Delete are the users that would be deleted, and Block the ones that
+	// would be blocked by reconcile.
+	Delete []string
+	Block  []string
+	// CreateRoles are the missing roles that would be created.
+	CreateRoles []string
+	// AssignRoles are the roles that would be assigned to each user.
+	AssignRoles map[string][]string
+
+	received int32
+	errors   int32
+	planned  map[string]bool
+}
+
+func newPlan() *Plan {
+	return &Plan{
+		AssignRoles: make(map[string][]string),
+		planned:     make(map[string]bool),
+	}
+}
+
+// Plan returns the changes planned by a dry run, or nil if the plugin is not
+// configured to run dry.
+func (s *Auth0Plugin) Plan() *Plan {
+	return s.plan
+}
+
+// stats returns the stats the planned changes would have produced.
+func (p *Plan) stats() *plugin.Stats {
+	return &plugin.Stats{
+		Received: p.received,
+		Created:  int32(len(p.Create)),
+		Updated:  int32(len(p.Update) + len(p.Block)),
+		Deleted:  int32(len(p.Delete)),
+		Errors:   p.errors,
+	}
+}
+
+// closePlan completes the plan of the operation, logs it and returns the
+// stats it would have produced.
+func (s *Auth0Plugin) closePlan() (*plugin.Stats, error) {
+	var errs error
+	if s.Config.AssignRoles {
+		failed, err := s.planRoles()
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		s.plan.errors += failed
+	}
+
+	if s.op == plugin.OperationTypeWrite && s.reconciling() {
+		_, failed, err := s.reconcile(false)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		s.plan.errors += failed
+	}
+
+	stats := s.plan.stats()
+	stats.Received += int32(s.Skipped())
+
+	s.plan.log()
+
+	return stats, errs
+}
+
+// log logs every planned change, followed by their totals, since the plan is
+// not part of the stats returned to the plugin host.
+func (p *Plan) log() {
+	for _, id := range p.Create {
+		log.Printf("auth0 dry run would create user %s", id)
+	}
+	for _, id := range p.Update {
+		log.Printf("auth0 dry run would update user %s", id)
+	}
+	for _, id := range p.Delete {
+		log.Printf("auth0 dry run would delete user %s", id)
+	}
+	for _, id := range p.Block {
+		log.Printf("auth0 dry run would block user %s", id)
+	}
+	for _, name := range p.CreateRoles {
+		log.Printf("auth0 dry run would create role %s", name)
+	}
+
+	ids := make([]string, 0, len(p.AssignRoles))
+	for id := range p.AssignRoles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log.Printf("auth0 dry run would assign roles %s to user %s", strings.Join(p.AssignRoles[id], ", "), id)
+	}
+
+	log.Printf("auth0 dry run would create %d, update %d, delete %d and block %d users, and create %d roles",
+		len(p.Create), len(p.Update), len(p.Delete), len(p.Block), len(p.CreateRoles))
+}
+
+// planImport adds the users of an import job to the plan instead of
+// submitting it.
+func (s *Auth0Plugin) planImport(users []map[string]interface{}) error {
+	for _, user := range users {
+		s.plan.received++
+
+		id := userIdentifier(user)
+		if s.plan.planned[id] {
+			// imported twice, the second import updates the first one
+			s.plan.Update = append(s.plan.Update, id)
+			continue
+		}
+		s.plan.planned[id] = true
+
+		userID, _ := user["user_id"].(string)
+		email, _ := user["email"].(string)
+		exists, err := s.userExists(userID, email)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			s.plan.Update = append(s.plan.Update, id)
+		} else {
+			s.plan.Create = append(s.plan.Create, id)
+		}
+	}
+
+	return nil
+}
+
+// planDelete adds a user to the plan instead of deleting it. Deleting users
+// that do not exist is a no-op in Auth0, so they are left out of the plan.
+func (s *Auth0Plugin) planDelete(userID string) error {
+	s.plan.received++
+
+	_, err := s.mgmt.User.Read(userID, management.Context(s.context()))
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		s.plan.errors++
+		return err
+	}
+
+	s.plan.Delete = append(s.plan.Delete, userID)
+	return nil
+}
+
+// planRoles adds the role assignments of the imported users to the plan,
+// failing for the same users the assignment would fail for.
+func (s *Auth0Plugin) planRoles() (int32, error) {
+	if len(s.roleAssignments) == 0 {
+		return 0, nil
+	}
+
+	roles, err := s.listRoles()
+	if err != nil {
+		return int32(len(s.roleAssignments)), fmt.Errorf("failed to list Auth0 roles: %w", err)
+	}
+
+	var errs error
+	failed := int32(0)
+	for _, assignment := range s.roleAssignments {
+		id := assignment.userID
+		if id == "" {
+			id = assignment.email
+		}
+
+		err := s.planUserRoles(id, assignment.roles, roles)
+		if err != nil {
+			failed++
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return failed, errs
+}
+
+func (s *Auth0Plugin) planUserRoles(id string, names []string, roles map[string]*management.Role) error {
+	for _, name := range names {
+		if _, ok := roles[name]; ok {
+			continue
+		}
+
+		if !s.Config.CreateMissingRoles {
+			return fmt.Errorf("failed to assign roles to user %s: role %s does not exist", id, name)
+		}
+
+		roles[name] = &management.Role{Name: auth0.String(name)}
+		s.plan.CreateRoles = append(s.plan.CreateRoles, name)
+	}
+
+	s.plan.AssignRoles[id] = append(s.plan.AssignRoles[id], names...)
+	return nil
+}
+
+// userExists returns true if an imported user already exists in the target
+// connection, matching it by id when it has one and by email otherwise.
+func (s *Auth0Plugin) userExists(userID, email string) (bool, error) {
+	if userID != "" {
+		_, err := s.mgmt.User.Read("auth0|"+userID, management.Context(s.context()))
+		if err == nil {
+			return true, nil
+		}
+		if !isNotFound(err) {
+			return false, fmt.Errorf("failed to get user %s: %w", userID, err)
+		}
+		return false, nil
+	}
+
+	users, err := s.mgmt.User.ListByEmail(email, management.Context(s.context()))
+	if err != nil {
+		return false, fmt.Errorf("failed to get user by email %s: %w", email, err)
+	}
+
+	for _, user := range users {
+		for _, identity := range user.Identities {
+			if identity.GetConnection() == s.Config.ConnectionName {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func isNotFound(err error) bool {
+	var mErr management.Error
+	return errors.As(err, &mErr) && mErr.Status() == http.StatusNotFound
+}
@@ -0,0 +1,125 @@
+package srv
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.DryRun = true
+	cfg.AssignRoles = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	existing := auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")
+	existing.Attributes.Roles = []string{"viewer"}
+	assert.NoError(auth0Plugin.Write(existing))
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("", "April Stewart", "april.stewart@test.com", "pic")))
+
+	created := auth0TestUtils.CreateTestAPIUser("", "New User", "new.user@test.com", "pic")
+	created.Attributes.Roles = []string{"auditor"}
+	assert.NoError(auth0Plugin.Write(created))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "failed to assign roles to user new.user@test.com: role auditor does not exist")
+	assert.Equal(int32(3), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(2), stats.Updated)
+	assert.Equal(int32(1), stats.Errors)
+
+	plan := auth0Plugin.Plan()
+	assert.Equal([]string{"new.user@test.com"}, plan.Create)
+	assert.Equal([]string{"2ff319e101e1", "april.stewart@test.com"}, plan.Update)
+	assert.Equal(map[string][]string{"2ff319e101e1": {"viewer"}}, plan.AssignRoles)
+
+	// the plan is logged, the plugin host never sees it otherwise
+	assert.Contains(logs.String(), "auth0 dry run would create user new.user@test.com\n")
+	assert.Contains(logs.String(), "auth0 dry run would update user 2ff319e101e1\n")
+	assert.Contains(logs.String(), "auth0 dry run would update user april.stewart@test.com\n")
+	assert.Contains(logs.String(), "auth0 dry run would assign roles viewer to user 2ff319e101e1\n")
+	assert.Contains(logs.String(), "auth0 dry run would create 1, update 2, delete 0 and block 0 users, and create 0 roles\n")
+
+	assert.Empty(fake.Imports())
+	assert.Empty(fake.UserRoles("auth0|2ff319e101e1"))
+}
+
+func TestWriteDryRunCreateMissingRoles(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.DryRun = true
+	cfg.AssignRoles = true
+	cfg.CreateMissingRoles = true
+	cfg.MaxJobUsers = 1
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	for _, id := range []string{"7d0b52e3c9e0", "8e1c63f4d0f1"} {
+		user := auth0TestUtils.CreateTestAPIUser(id, "New User", id+"@test.com", "pic")
+		user.Attributes.Roles = []string{"admin", "auditor"}
+		assert.NoError(auth0Plugin.Write(user))
+	}
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(2), stats.Created)
+	assert.Equal(int32(0), stats.Errors)
+
+	plan := auth0Plugin.Plan()
+	assert.Equal([]string{"auditor"}, plan.CreateRoles)
+	assert.Equal([]string{"admin", "auditor"}, plan.AssignRoles["8e1c63f4d0f1"])
+	assert.Empty(fake.Imports())
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.DryRun = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeDelete)
+	assert.NoError(err)
+
+	assert.NoError(auth0Plugin.Delete("auth0|2ff319e101e1"))
+	assert.NoError(auth0Plugin.Delete("auth0|missing"))
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Deleted)
+	assert.Equal([]string{"auth0|2ff319e101e1"}, auth0Plugin.Plan().Delete)
+	assert.NotNil(fake.User("auth0|2ff319e101e1"))
+}
+
+func TestWriteWithoutDryRunHasNoPlan(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.Nil(auth0Plugin.Plan())
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+}
@@ -0,0 +1,120 @@
+package srv
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"gopkg.in/auth0.v5/management"
+)
+
+const (
+	defaultPageSize        = 50
+	defaultReadConcurrency = 4
+)
+
+type pageResult struct {
+	users []*api.User
+	err   error
+}
+
+// prefetcher reads the pages of the users list ahead of Read, with at most
+// concurrency pages being fetched or waiting to be read at any time. Pages
+// are returned in order.
+type prefetcher struct {
+	pages  chan chan pageResult
+	slots  chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startPrefetch starts reading the pages from first to last, inclusive,
+// listed with the given options.
+func (s *Auth0Plugin) startPrefetch(first, last int, opts []management.RequestOption) *prefetcher {
+	concurrency := s.Config.ReadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultReadConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(s.context())
+	p := &prefetcher{
+		pages:  make(chan chan pageResult, concurrency),
+		slots:  make(chan struct{}, concurrency),
+		cancel: cancel,
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.pages)
+
+		for page := first; page <= last; page++ {
+			select {
+			case p.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			result := make(chan pageResult, 1)
+			p.pages <- result
+
+			p.wg.Add(1)
+			go func(page int) {
+				defer p.wg.Done()
+				result <- s.fetchPage(ctx, page, opts)
+			}(page)
+		}
+	}()
+
+	return p
+}
+
+// fetchPage lists a page of users and transforms them.
+func (s *Auth0Plugin) fetchPage(ctx context.Context, page int, opts []management.RequestOption) pageResult {
+	opts = append([]management.RequestOption{management.Page(page), management.Context(ctx)}, opts...)
+
+	ul, err := s.mgmt.User.List(opts...)
+	if err != nil {
+		return pageResult{err: err}
+	}
+
+	users := make([]*api.User, 0, len(ul.Users))
+	for _, u := range ul.Users {
+		user, err := s.toAPIUser(u)
+		if err != nil {
+			return pageResult{err: err}
+		}
+		if user == nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return pageResult{users: users}
+}
+
+// next returns the users of the next page, or io.EOF once all the pages were
+// read.
+func (p *prefetcher) next() ([]*api.User, error) {
+	result, ok := <-p.pages
+	if !ok {
+		return nil, io.EOF
+	}
+
+	r := <-result
+	<-p.slots
+
+	return r.users, r.err
+}
+
+// stop cancels the pending requests and waits for them to return.
+func (p *prefetcher) stop() {
+	p.cancel()
+
+	go func() {
+		for range p.pages {
+		}
+	}()
+	p.wg.Wait()
+}
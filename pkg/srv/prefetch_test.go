@@ -0,0 +1,123 @@
+package srv
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func addUsers(fake *auth0TestUtils.FakeAuth0, count int) {
+	for i := 0; i < count; i++ {
+		fake.AddUser(map[string]interface{}{
+			"user_id": fmt.Sprintf("auth0|page%04d", i),
+			"email":   fmt.Sprintf("page%04d@test.com", i),
+		})
+	}
+}
+
+func TestReadPrefetch(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.PageSize = 100
+	cfg.ReadConcurrency = 3
+	cfg.ReadMode = config.ReadModePage
+	addUsers(fake, 947)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	first, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(100, len(first))
+
+	users := append(first, readAll(t, auth0Plugin)...)
+	assert.Equal(950, len(users))
+
+	// users are sorted by id, the page users come after the fixtures
+	for i := 0; i < 947; i++ {
+		assert.Equal(fmt.Sprintf("page%04d@test.com", i), users[i+3].Email)
+	}
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+}
+
+func TestReadPrefetchListingLimit(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.PageSize = 100
+	cfg.ReadMode = config.ReadModePage
+	addUsers(fake, 1097)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	// pages past the listing limit are not requested, Auth0 rejects them
+	first, err := auth0Plugin.Read()
+	assert.NoError(err)
+	users := append(first, readAll(t, auth0Plugin)...)
+	assert.Equal(1000, len(users))
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+}
+
+func TestReadPrefetchClosedEarly(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.ReadMode = config.ReadModePage
+	addUsers(fake, 500)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(50, len(users))
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Nil(auth0Plugin.prefetch)
+}
+
+func TestReadPrefetchError(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.ReadMode = config.ReadModePage
+	cfg.PageSize = 100
+	cfg.ReadConcurrency = 1
+	addUsers(fake, 497)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	_, err = auth0Plugin.Read()
+	assert.NoError(err)
+
+	// a single page is fetched ahead, the following ones are throttled
+	fake.Throttle(1000)
+
+	var pages int
+	for err == nil {
+		_, err = auth0Plugin.Read()
+		pages++
+	}
+	assert.LessOrEqual(pages, 2)
+	assert.Contains(err.Error(), "rate limit exceeded")
+
+	_, err = auth0Plugin.Read()
+	assert.Equal(io.EOF, err)
+}
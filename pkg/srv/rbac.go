@@ -0,0 +1,219 @@
+package srv
+
+import (
+	"fmt"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	multierror "github.com/hashicorp/go-multierror"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+// enrichRBAC fills the roles and the effective permissions (granted directly
+// or through a role) of an Aserto user from the ones assigned in Auth0.
+func (s *Auth0Plugin) enrichRBAC(auth0ID string, user *api.User) error {
+	roles, err := s.userRoles(auth0ID)
+	if err != nil {
+		return fmt.Errorf("failed to get roles of user %s: %w", auth0ID, err)
+	}
+
+	permissions, err := s.userPermissions(auth0ID)
+	if err != nil {
+		return fmt.Errorf("failed to get permissions of user %s: %w", auth0ID, err)
+	}
+
+	if user.Attributes == nil {
+		user.Attributes = &api.AttrSet{}
+	}
+	user.Attributes.Roles = roles
+	user.Attributes.Permissions = permissions
+
+	return nil
+}
+
+func (s *Auth0Plugin) userRoles(auth0ID string) ([]string, error) {
+	roles := []string{}
+
+	for page := 0; ; page++ {
+		rl, err := s.mgmt.User.Roles(auth0ID, management.Page(page))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, role := range rl.Roles {
+			roles = append(roles, auth0.StringValue(role.Name))
+		}
+
+		if !rl.HasNext() {
+			return roles, nil
+		}
+	}
+}
+
+func (s *Auth0Plugin) userPermissions(auth0ID string) ([]string, error) {
+	permissions := []string{}
+	seen := make(map[string]bool)
+
+	for page := 0; ; page++ {
+		pl, err := s.mgmt.User.Permissions(auth0ID, management.Page(page))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, permission := range pl.Permissions {
+			if s.Config.PermissionsAudience != "" &&
+				auth0.StringValue(permission.ResourceServerIdentifier) != s.Config.PermissionsAudience {
+				continue
+			}
+
+			name := auth0.StringValue(permission.Name)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			permissions = append(permissions, name)
+		}
+
+		if !pl.HasNext() {
+			return permissions, nil
+		}
+	}
+}
+
+// roleAssignment holds the roles to assign to an imported user, once the
+// import jobs complete.
+type roleAssignment struct {
+	userID string
+	email  string
+	roles  []string
+}
+
+func (s *Auth0Plugin) addRoleAssignment(user *api.User) {
+	if user.Attributes == nil || len(user.Attributes.Roles) == 0 {
+		return
+	}
+
+	s.roleAssignments = append(s.roleAssignments, roleAssignment{
+		userID: user.Id,
+		email:  user.Email,
+		roles:  user.Attributes.Roles,
+	})
+}
+
+// identifier returns the id of the user, or its email when it has no id, as
+// reported by the import jobs.
+func (a roleAssignment) identifier() string {
+	if a.userID != "" {
+		return a.userID
+	}
+	return a.email
+}
+
+// assignRoles assigns the roles of the imported users in Auth0, returning the
+// number of users whose roles could not be assigned. Users that were not
+// imported are skipped, the import already counted them as errors.
+func (s *Auth0Plugin) assignRoles(notImported map[string]bool) (int32, error) {
+	var assignments []roleAssignment
+	for _, assignment := range s.roleAssignments {
+		if !notImported[assignment.identifier()] {
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	if len(assignments) == 0 {
+		return 0, nil
+	}
+
+	roles, err := s.listRoles()
+	if err != nil {
+		return int32(len(assignments)), fmt.Errorf("failed to list Auth0 roles: %w", err)
+	}
+
+	var errs error
+	failed := int32(0)
+	for _, assignment := range assignments {
+		err := s.assignUserRoles(assignment, roles)
+		if err != nil {
+			failed++
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return failed, errs
+}
+
+func (s *Auth0Plugin) assignUserRoles(assignment roleAssignment, roles map[string]*management.Role) error {
+	auth0ID, err := s.resolveUserID(assignment.userID, assignment.email)
+	if err != nil {
+		return err
+	}
+
+	userRoles := make([]*management.Role, 0, len(assignment.roles))
+	for _, name := range assignment.roles {
+		role, ok := roles[name]
+		if !ok {
+			if !s.Config.CreateMissingRoles {
+				return fmt.Errorf("failed to assign roles to user %s: role %s does not exist", auth0ID, name)
+			}
+
+			role = &management.Role{Name: auth0.String(name)}
+			err := s.mgmt.Role.Create(role)
+			if err != nil {
+				return fmt.Errorf("failed to create role %s: %w", name, err)
+			}
+			roles[name] = role
+		}
+		userRoles = append(userRoles, role)
+	}
+
+	err = s.mgmt.User.AssignRoles(auth0ID, userRoles)
+	if err != nil {
+		return fmt.Errorf("failed to assign roles to user %s: %w", auth0ID, err)
+	}
+
+	return nil
+}
+
+// listRoles returns all the roles defined in Auth0, indexed by name.
+func (s *Auth0Plugin) listRoles() (map[string]*management.Role, error) {
+	roles := make(map[string]*management.Role)
+
+	for page := 0; ; page++ {
+		rl, err := s.mgmt.Role.List(management.Page(page))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, role := range rl.Roles {
+			roles[auth0.StringValue(role.Name)] = role
+		}
+
+		if !rl.HasNext() {
+			return roles, nil
+		}
+	}
+}
+
+// resolveUserID returns the Auth0 id of an imported user. Users imported with
+// an id get it prefixed by the database provider, the others are looked up
+// by email in the target connection.
+func (s *Auth0Plugin) resolveUserID(userID, email string) (string, error) {
+	if userID != "" {
+		return "auth0|" + userID, nil
+	}
+
+	users, err := s.mgmt.User.ListByEmail(email)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user by email %s: %w", email, err)
+	}
+
+	for _, user := range users {
+		for _, identity := range user.Identities {
+			if identity.GetConnection() == s.Config.ConnectionName {
+				return user.GetID(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("failed to get user by email %s in connection %s", email, s.Config.ConnectionName)
+}
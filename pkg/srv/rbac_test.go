@@ -0,0 +1,219 @@
+package srv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+func TestReadRBAC(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.UserPID = "6b0dbf0a8f2b"
+	cfg.IncludeRBAC = true
+	cfg.PermissionsAudience = "https://api.test.com"
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(1, len(users))
+	assert.Equal([]string{"admin"}, users[0].Attributes.Roles)
+	assert.Equal([]string{"read:reports"}, users[0].Attributes.Permissions)
+}
+
+func TestReadRBACAllAudiences(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.IncludeRBAC = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	// permissions granted by several roles are only listed once
+	admin := &management.Role{ID: auth0.String("rol_1")}
+	assert.NoError(auth0Plugin.mgmt.User.AssignRoles("auth0|6b0dbf0a8f2b", []*management.Role{admin}))
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(3, len(users))
+
+	for _, user := range users {
+		if user.Email == "april.stewart@test.com" {
+			assert.Equal([]string{"read:reports", "manage:tenant"}, user.Attributes.Permissions)
+			continue
+		}
+		assert.Equal([]string{}, user.Attributes.Roles)
+		assert.Equal([]string{}, user.Attributes.Permissions)
+	}
+}
+
+func TestReadRBACPages(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.UserPID = "2ff319e101e1"
+	cfg.IncludeRBAC = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	var roles []*management.Role
+	var names []string
+	for i := 0; i < 60; i++ {
+		role := &management.Role{Name: auth0.String(fmt.Sprintf("role%02d", i))}
+		assert.NoError(auth0Plugin.mgmt.Role.Create(role))
+		roles = append(roles, role)
+		names = append(names, role.GetName())
+	}
+	assert.NoError(auth0Plugin.mgmt.User.AssignRoles("auth0|2ff319e101e1", roles))
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(1, len(users))
+	assert.Equal(names, users[0].Attributes.Roles)
+}
+
+func TestReadRBACUnknownUser(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.IncludeRBAC = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	err = auth0Plugin.enrichRBAC("auth0|unknown", auth0TestUtils.CreateTestAPIUser("unknown", "Unknown", "unknown@test.com", "pic"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "failed to get roles of user auth0|unknown")
+}
+
+func TestWriteAssignRoles(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.AssignRoles = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	withID := auth0TestUtils.CreateTestAPIUser("7d0b52e3c9e0", "New User", "new.user@test.com", "pic")
+	withID.Attributes.Roles = []string{"viewer"}
+	assert.NoError(auth0Plugin.Write(withID))
+
+	withoutID := auth0TestUtils.CreateTestAPIUser("", "Other User", "other.user@test.com", "pic")
+	withoutID.Attributes.Roles = []string{"auditor"}
+	assert.NoError(auth0Plugin.Write(withoutID))
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "role auditor does not exist")
+	assert.Equal(int32(2), stats.Created)
+	assert.Equal(int32(1), stats.Errors)
+	assert.Equal([]string{"viewer"}, fake.UserRoles("auth0|7d0b52e3c9e0"))
+
+	cfg.CreateMissingRoles = true
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(withoutID))
+
+	stats, err = auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(1), stats.Updated)
+	assert.Equal(int32(0), stats.Errors)
+	assert.Equal([]string{"auditor"}, fake.UserRoles("auth0|imported1"))
+}
+
+func TestWriteAssignRolesRejectedUser(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.AssignRoles = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	big := auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", strings.Repeat("p", int(maxBatchSize)))
+	big.Attributes.Roles = []string{"viewer"}
+	assert.Error(auth0Plugin.Write(big))
+	assert.Empty(auth0Plugin.roleAssignments)
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(0), stats.Errors)
+	assert.Empty(fake.UserRoles("auth0|2ff319e101e1"))
+}
+
+func TestWriteAssignRolesImportError(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.AssignRoles = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	valid := auth0TestUtils.CreateTestAPIUser("1", "Valid User", "valid@test.com", "pic")
+	valid.Attributes.Roles = []string{"viewer"}
+	assert.NoError(auth0Plugin.Write(valid))
+
+	invalid := auth0TestUtils.CreateTestAPIUser("2", "Invalid User", "invalid", "pic")
+	invalid.Attributes.Roles = []string{"viewer"}
+	assert.NoError(auth0Plugin.Write(invalid))
+
+	// the user the import job rejected is only counted once, roles are not
+	// assigned to it
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.NotContains(err.Error(), "failed to assign roles")
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(1), stats.Errors)
+	assert.Equal([]string{"viewer"}, fake.UserRoles("auth0|1"))
+	assert.Empty(fake.UserRoles("auth0|2"))
+}
+
+func TestResolveUserID(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	fake.AddUser(map[string]interface{}{
+		"user_id": "google-oauth2|1234",
+		"email":   "social.user@test.com",
+		"identities": []interface{}{
+			map[string]interface{}{"connection": "google-oauth2", "provider": "google-oauth2", "user_id": "1234", "isSocial": true},
+		},
+	})
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	id, err := auth0Plugin.resolveUserID("2ff319e101e1", "")
+	assert.NoError(err)
+	assert.Equal("auth0|2ff319e101e1", id)
+
+	id, err = auth0Plugin.resolveUserID("", "April.Stewart@test.com")
+	assert.NoError(err)
+	assert.Equal("auth0|6b0dbf0a8f2b", id)
+
+	// users of other connections are not assigned roles
+	_, err = auth0Plugin.resolveUserID("", "social.user@test.com")
+	assert.Error(err)
+	assert.Equal("failed to get user by email social.user@test.com in connection Username-Password-Authentication", err.Error())
+}
@@ -0,0 +1,156 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	multierror "github.com/hashicorp/go-multierror"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+)
+
+const defaultMaxDeletions = 100
+
+// addWritten records a written user, so reconcile leaves it in place. Users
+// are matched by id when they have one, and by email otherwise.
+func (s *Auth0Plugin) addWritten(user *api.User) {
+	if !s.reconciling() {
+		return
+	}
+
+	if s.written == nil {
+		s.written = make(map[string]bool)
+	}
+
+	if user.Id != "" {
+		s.written["auth0|"+user.Id] = true
+	}
+	if user.Email != "" {
+		s.written[strings.ToLower(user.Email)] = true
+	}
+}
+
+// reconciling returns true if the users of the connection that are not
+// written are removed once the write completes.
+func (s *Auth0Plugin) reconciling() bool {
+	return s.Config.Reconcile == config.ReconcileDelete || s.Config.Reconcile == config.ReconcileBlock
+}
+
+func (s *Auth0Plugin) wasWritten(u *management.User) bool {
+	return s.written[u.GetID()] || (u.GetEmail() != "" && s.written[strings.ToLower(u.GetEmail())])
+}
+
+// maxDeletions returns the maximum number of users reconcile can remove.
+func (s *Auth0Plugin) maxDeletions() int {
+	if s.Config.MaxDeletions > 0 {
+		return s.Config.MaxDeletions
+	}
+	return defaultMaxDeletions
+}
+
+// reconcile deletes or blocks the users of the target connection that were
+// not written, returning the number of users removed and the number of
+// users that could not be. Nothing is removed if more users than the
+// maximum number of deletions are missing, or if the write did not complete,
+// since the written users are then not known.
+func (s *Auth0Plugin) reconcile(importFailed bool) (int32, int32, error) {
+	if !s.opened || s.connectionID == "" {
+		return 0, 0, fmt.Errorf("reconcile of connection %s skipped: the connection was not opened", s.Config.ConnectionName)
+	}
+	if importFailed || s.writeFailed {
+		return 0, 0, fmt.Errorf("reconcile of connection %s skipped: not all users were written", s.Config.ConnectionName)
+	}
+
+	stale, err := s.staleUsers()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reconcile connection %s: %w", s.Config.ConnectionName, err)
+	}
+
+	if len(stale) > s.maxDeletions() {
+		return 0, 0, fmt.Errorf("reconcile would remove %d users of connection %s, more than the maximum of %d; no user was removed",
+			len(stale), s.Config.ConnectionName, s.maxDeletions())
+	}
+
+	var errs error
+	removed, failed := int32(0), int32(0)
+	for _, u := range stale {
+		if err := s.removeStaleUser(u); err != nil {
+			failed++
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 && s.plan == nil {
+		log.Printf("auth0 reconcile %s %d users of connection %s", s.reconcileVerb(), removed, s.Config.ConnectionName)
+	}
+
+	return removed, failed, errs
+}
+
+// staleUsers returns the users of the target connection that were not
+// written, leaving out the ones already blocked when blocking.
+func (s *Auth0Plugin) staleUsers() ([]*management.User, error) {
+	export, err := s.exportUsers(s.connectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer export.Close()
+
+	var stale []*management.User
+	for {
+		users, err := export.next(exportBatchSize)
+		if errors.Is(err, io.EOF) {
+			return stale, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range users {
+			if s.wasWritten(u) {
+				continue
+			}
+			if s.Config.Reconcile == config.ReconcileBlock && u.GetBlocked() {
+				continue
+			}
+			stale = append(stale, u)
+		}
+	}
+}
+
+func (s *Auth0Plugin) removeStaleUser(u *management.User) error {
+	if s.plan != nil {
+		if s.Config.Reconcile == config.ReconcileBlock {
+			s.plan.Block = append(s.plan.Block, u.GetID())
+		} else {
+			s.plan.Delete = append(s.plan.Delete, u.GetID())
+		}
+		return nil
+	}
+
+	if s.Config.Reconcile == config.ReconcileBlock {
+		if err := s.mgmt.User.Update(u.GetID(), &management.User{Blocked: auth0.Bool(true)}); err != nil {
+			return fmt.Errorf("failed to block user %s: %w", u.GetID(), err)
+		}
+		return nil
+	}
+
+	if err := s.mgmt.User.Delete(u.GetID()); err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", u.GetID(), err)
+	}
+	return nil
+}
+
+func (s *Auth0Plugin) reconcileVerb() string {
+	if s.Config.Reconcile == config.ReconcileBlock {
+		return "blocked"
+	}
+	return "deleted"
+}
@@ -0,0 +1,147 @@
+package srv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReconcileDelete(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileDelete
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("", "April Stewart", "april.stewart@test.com", "pic")))
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("7d0b52e3c9e0", "New User", "new.user@test.com", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(2), stats.Updated)
+	assert.Equal(int32(1), stats.Deleted)
+
+	assert.NotNil(fake.User("auth0|2ff319e101e1"))
+	assert.NotNil(fake.User("auth0|6b0dbf0a8f2b"))
+	assert.NotNil(fake.User("auth0|7d0b52e3c9e0"))
+	assert.Nil(fake.User("auth0|b3c4e7f3c8a1"))
+}
+
+func TestWriteReconcileBlock(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileBlock
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(3), stats.Updated)
+	assert.Equal(int32(0), stats.Deleted)
+	assert.Equal(true, fake.User("auth0|6b0dbf0a8f2b")["blocked"])
+	assert.Equal(true, fake.User("auth0|b3c4e7f3c8a1")["blocked"])
+	assert.Nil(fake.User("auth0|2ff319e101e1")["blocked"])
+
+	// users already blocked are not blocked again
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+
+	stats, err = auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(1), stats.Updated)
+}
+
+func TestWriteReconcileMaxDeletions(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileDelete
+	cfg.MaxDeletions = 1
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "reconcile would remove 2 users of connection Username-Password-Authentication, more than the maximum of 1; no user was removed")
+	assert.Equal(int32(0), stats.Deleted)
+	assert.NotNil(fake.User("auth0|6b0dbf0a8f2b"))
+	assert.NotNil(fake.User("auth0|b3c4e7f3c8a1"))
+}
+
+func TestWriteReconcileDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileDelete
+	cfg.DryRun = true
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(1), stats.Updated)
+	assert.Equal(int32(2), stats.Deleted)
+	assert.Equal([]string{"auth0|6b0dbf0a8f2b", "auth0|b3c4e7f3c8a1"}, auth0Plugin.Plan().Delete)
+	assert.NotNil(fake.User("auth0|6b0dbf0a8f2b"))
+	assert.NotNil(fake.User("auth0|b3c4e7f3c8a1"))
+}
+
+func TestWriteReconcileFailedOpen(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileDelete
+	cfg.ConnectionName = "does-not-exist"
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.Error(err)
+
+	// the SDK closes the plugin even when it fails to open
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Nil(stats)
+	assert.NotNil(fake.User("auth0|2ff319e101e1"))
+	assert.NotNil(fake.User("auth0|6b0dbf0a8f2b"))
+	assert.NotNil(fake.User("auth0|b3c4e7f3c8a1"))
+}
+
+func TestWriteReconcileWriteError(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Reconcile = config.ReconcileDelete
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "pic")))
+	assert.Error(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("6b0dbf0a8f2b", "April Stewart", "april.stewart@test.com", strings.Repeat("p", int(maxBatchSize)))))
+
+	stats, err := auth0Plugin.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "reconcile of connection Username-Password-Authentication skipped: not all users were written")
+	assert.Equal(int32(0), stats.Deleted)
+	assert.NotNil(fake.User("auth0|6b0dbf0a8f2b"))
+	assert.NotNil(fake.User("auth0|b3c4e7f3c8a1"))
+}
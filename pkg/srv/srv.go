@@ -1,16 +1,20 @@
 package srv
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/filter"
+	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/ratelimit"
 	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/transform"
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
@@ -26,16 +30,30 @@ const (
 )
 
 type Auth0Plugin struct {
-	Config       *config.Auth0Config
-	mgmt         *management.Management
-	page         int
-	finishedRead bool
-	totalSize    int64
-	jobs         []management.Job
-	users        []map[string]interface{}
-	connectionID string
-	wg           sync.WaitGroup
-	op           plugin.OperationType
+	Config          *config.Auth0Config
+	mgmt            *management.Management
+	page            int
+	finishedRead    bool
+	jobs            []management.Job
+	batch           *batch
+	connectionID    string
+	export          *exportReader
+	prefetch        *prefetcher
+	delta           *deltaWindow
+	mapping         *transform.Mapping
+	filter          *filter.Filter
+	skipped         int64
+	roleAssignments []roleAssignment
+	rateLimit       *ratelimit.Transport
+	plan            *Plan
+	written         map[string]bool
+	writeFailed     bool
+	opened          bool
+	op              plugin.OperationType
+	ctx             context.Context
+	cancel          context.CancelFunc
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
 }
 
 func NewAuth0Plugin() *Auth0Plugin {
@@ -53,29 +71,36 @@ func (s *Auth0Plugin) GetVersion() (string, string, string) {
 }
 
 func (s *Auth0Plugin) Open(cfg plugin.Config, operation plugin.OperationType) error {
+	// Close is called even when Open fails, it must know nothing was opened
+	s.opened = false
+
 	auth0Config, ok := cfg.(*config.Auth0Config)
 	if !ok {
 		return errors.New("invalid config")
 	}
 
+	if err := auth0Config.ResolveSecrets(); err != nil {
+		return err
+	}
+
 	if auth0Config.UserPID != "" && !strings.HasPrefix(auth0Config.UserPID, "auth0|") {
 		auth0Config.UserPID = "auth0|" + auth0Config.UserPID
 	}
 
-	s.Config = auth0Config
-	s.page = 0
-	s.finishedRead = false
-	s.op = operation
+	if auth0Config.ReadMode == "" {
+		auth0Config.ReadMode = config.ReadModeAuto
+	}
+
+	s.reset(auth0Config, operation)
 
-	mgmt, err := management.New(
-		auth0Config.Domain,
-		management.WithClientCredentials(
-			auth0Config.ClientID,
-			auth0Config.ClientSecret,
-		))
+	if err := s.loadOptions(); err != nil {
+		return err
+	}
 
+	s.rateLimit = ratelimit.NewTransport(nil)
+	mgmt, err := auth0Config.NewManagement(s.rateLimit)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	s.mgmt = mgmt
@@ -92,6 +117,64 @@ func (s *Auth0Plugin) Open(cfg plugin.Config, operation plugin.OperationType) er
 		s.connectionID = auth0.StringValue(c.ID)
 	}
 
+	s.opened = true
+
+	return nil
+}
+
+// reset clears the state left by a previous operation.
+func (s *Auth0Plugin) reset(cfg *config.Auth0Config, operation plugin.OperationType) {
+	s.Config = cfg
+	s.page = 0
+	s.finishedRead = false
+	s.export = nil
+	s.prefetch = nil
+	s.delta = nil
+	s.mapping = nil
+	s.filter = nil
+	s.skipped = 0
+	s.plan = nil
+	s.written = nil
+	s.writeFailed = false
+	s.connectionID = ""
+	s.roleAssignments = nil
+	s.jobs = nil
+	s.batch = newBatch(maxBatchSize, cfg.MaxJobUsers)
+	s.op = operation
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	if cfg.DryRun {
+		s.plan = newPlan()
+	}
+}
+
+// loadOptions compiles the mapping file, the filter and the updated-since
+// timestamp of the configuration.
+func (s *Auth0Plugin) loadOptions() error {
+	if s.Config.MappingFile != "" {
+		mapping, err := transform.LoadMapping(s.Config.MappingFile)
+		if err != nil {
+			return fmt.Errorf("invalid mapping file: %w", err)
+		}
+		s.mapping = mapping
+	}
+
+	if s.Config.Filter != "" {
+		f, err := filter.Compile(s.Config.Filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		s.filter = f
+	}
+
+	if s.Config.UpdatedSince != "" {
+		since, err := time.Parse(time.RFC3339, s.Config.UpdatedSince)
+		if err != nil {
+			return fmt.Errorf("invalid updated-since timestamp %q: %w", s.Config.UpdatedSince, err)
+		}
+		s.delta = newDeltaWindow(since)
+	}
+
 	return nil
 }
 
@@ -100,7 +183,6 @@ func (s *Auth0Plugin) Read() ([]*api.User, error) {
 		return nil, io.EOF
 	}
 
-	var errs error
 	var users []*api.User
 
 	if s.Config.UserPID != "" {
@@ -108,7 +190,9 @@ func (s *Auth0Plugin) Read() ([]*api.User, error) {
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, user)
+		if user != nil {
+			users = append(users, user)
+		}
 		return users, nil
 	}
 
@@ -116,23 +200,96 @@ func (s *Auth0Plugin) Read() ([]*api.User, error) {
 		return s.readByEmail(s.Config.UserEmail)
 	}
 
-	opts := management.Page(s.page)
-	ul, err := s.mgmt.User.List(opts)
+	if s.export != nil {
+		return s.readExport()
+	}
+
+	if s.delta != nil {
+		return s.readDelta()
+	}
+
+	if s.Config.ReadMode == config.ReadModeExport {
+		if err := s.startExport(); err != nil {
+			return nil, err
+		}
+		return s.readExport()
+	}
+
+	if s.prefetch != nil {
+		users, err := s.prefetch.next()
+		if err != nil {
+			s.finishedRead = true
+			s.prefetch.stop()
+			s.prefetch = nil
+		}
+		return users, err
+	}
+
+	return s.readPages()
+}
+
+// readPages lists the first page of users and starts prefetching the
+// others, or switches to an export job in the auto read mode when the
+// tenant holds more users than can be listed.
+func (s *Auth0Plugin) readPages() ([]*api.User, error) {
+	var users []*api.User
+
+	opts := []management.RequestOption{management.PerPage(s.pageSize())}
+	if s.Config.Query != "" {
+		opts = append(opts, management.Query(s.Config.Query))
+	}
+
+	ul, err := s.mgmt.User.List(append(opts, management.Page(0))...)
 	if err != nil {
 		return nil, err
 	}
 
+	if s.Config.ReadMode == config.ReadModeAuto && ul.Total > maxPaginatedUsers {
+		// export jobs can not be filtered, so a query matching more users
+		// than the list endpoint returns can not be read completely
+		if s.Config.Query != "" {
+			return nil, fmt.Errorf("query matches %d users, more than the %d users Auth0 can list", ul.Total, maxPaginatedUsers)
+		}
+
+		if err := s.startExport(); err != nil {
+			return nil, err
+		}
+		return s.readExport()
+	}
+
 	for _, u := range ul.Users {
-		user := transform.Transform(u)
+		user, err := s.toAPIUser(u)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			continue
+		}
 
 		users = append(users, user)
 	}
-	if !ul.HasNext() {
+
+	if ul.HasNext() {
+		lastPage := (ul.Total - 1) / ul.Limit
+		if maxPage := maxPaginatedUsers/ul.Limit - 1; lastPage > maxPage {
+			log.Printf("auth0 can only list %d of the %d users in the %s read mode, use the %s read mode to read them all",
+				(maxPage+1)*ul.Limit, ul.Total, config.ReadModePage, config.ReadModeExport)
+			lastPage = maxPage
+		}
+		s.prefetch = s.startPrefetch(1, lastPage, opts)
+	} else {
 		s.finishedRead = true
 	}
-	s.page++
 
-	return users, errs
+	return users, nil
+}
+
+// pageSize returns the number of users listed per page.
+func (s *Auth0Plugin) pageSize() int {
+	if s.Config.PageSize > 0 {
+		return s.Config.PageSize
+	}
+	return defaultPageSize
 }
 
 func (s *Auth0Plugin) readByPID(id string) (*api.User, error) {
@@ -146,7 +303,7 @@ func (s *Auth0Plugin) readByPID(id string) (*api.User, error) {
 		return nil, fmt.Errorf("failed to get user by pid %s", id)
 	}
 
-	return transform.Transform(user), nil
+	return s.toAPIUser(user)
 }
 
 func (s *Auth0Plugin) readByEmail(email string) ([]*api.User, error) {
@@ -162,104 +319,327 @@ func (s *Auth0Plugin) readByEmail(email string) ([]*api.User, error) {
 	}
 
 	for _, user := range auth0Users {
-		apiUser := transform.Transform(user)
+		apiUser, err := s.toAPIUser(user)
+		if err != nil {
+			return nil, err
+		}
+		if apiUser == nil {
+			continue
+		}
 		users = append(users, apiUser)
 	}
 
 	return users, nil
 }
 
+// toAPIUser transforms an Auth0 user into an Aserto user, enriching it with
+// the data that is not part of the Auth0 user profile. It returns nil if the
+// user does not match the configured filter.
+func (s *Auth0Plugin) toAPIUser(in *management.User) (*api.User, error) {
+	if s.filter != nil {
+		match, err := s.filter.MatchAuth0(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter user %s: %w", in.GetID(), err)
+		}
+		if !match {
+			atomic.AddInt64(&s.skipped, 1)
+			return nil, nil
+		}
+	}
+
+	user, err := transform.Transform(in, s.transformOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform user %s: %w", in.GetID(), err)
+	}
+
+	if s.Config.IncludeRBAC {
+		err = s.enrichRBAC(in.GetID(), user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
 func (s *Auth0Plugin) Write(user *api.User) error {
-	u := transform.ToAuth0(user, transform.WithUserID())
+	err := s.write(user)
+	if err != nil {
+		// users that failed to be written must not be removed by reconcile
+		s.writeFailed = true
+	}
+	return err
+}
+
+func (s *Auth0Plugin) write(user *api.User) error {
+	// filtered users are left alone by reconcile too
+	s.addWritten(user)
+
+	if s.filter != nil {
+		match, err := s.filter.MatchUser(user)
+		if err != nil {
+			return fmt.Errorf("failed to filter user %s: %w", user.Id, err)
+		}
+		if !match {
+			atomic.AddInt64(&s.skipped, 1)
+			return nil
+		}
+	}
+
+	u, err := transform.ToAuth0(user, append(s.transformOptions(), transform.WithUserID())...)
+	if err != nil {
+		return fmt.Errorf("failed to transform user %s: %w", user.Id, err)
+	}
 
-	userMap, size, err := structToMap(u)
+	userMap, err := structToMap(u)
 	if err != nil {
 		return err
 	}
 
-	if s.totalSize+size < maxBatchSize {
-		s.users = append(s.users, userMap)
-	} else {
+	if s.Config.PasswordHashProperty != "" {
+		hash, err := transform.PasswordHash(user, s.Config.PasswordHashProperty)
+		if err != nil {
+			return fmt.Errorf("invalid password hash for user %s: %w", userIdentifier(userMap), err)
+		}
+		if hash != nil {
+			userMap["custom_password_hash"] = hash
+		}
+	}
+
+	size, err := jsonSize(userMap)
+	if err != nil {
+		return err
+	}
+
+	if !s.batch.fits(size) && !s.batch.empty() {
 		err = s.startJob()
 		if err != nil {
 			return err
 		}
-		s.users = make([]map[string]interface{}, 0)
-		s.users = append(s.users, userMap)
+	}
+
+	if !s.batch.fits(size) {
+		return fmt.Errorf("user %s exceeds the maximum import file size of %d bytes", userIdentifier(userMap), maxBatchSize)
+	}
+
+	s.batch.add(userMap, size)
+
+	// roles are only assigned to the users that are imported
+	if s.Config.AssignRoles {
+		s.addRoleAssignment(user)
 	}
 
 	return nil
 }
 
+// context returns the context of the current operation.
+func (s *Auth0Plugin) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// transformOptions returns the options used to transform users in both
+// directions, based on the plugin configuration.
+func (s *Auth0Plugin) transformOptions() []transform.Option {
+	var opts []transform.Option
+
+	switch s.Config.AppMetadata {
+	case config.AppMetadataWhole:
+		opts = append(opts, transform.WithAppMetadata(s.Config.AppMetadataApp))
+	case config.AppMetadataPerApplication:
+		opts = append(opts, transform.WithAppMetadataPerApplication())
+	}
+
+	if s.Config.PasswordHashProperty != "" {
+		opts = append(opts, transform.WithPasswordHash(s.Config.PasswordHashProperty))
+	}
+
+	if s.Config.MetadataIdentities {
+		opts = append(opts, transform.WithMetadataIdentities())
+	}
+
+	if s.mapping != nil {
+		opts = append(opts, transform.WithMapping(s.mapping))
+	}
+
+	return opts
+}
+
 func (s *Auth0Plugin) Delete(userID string) error {
 	if s.mgmt == nil {
 		return status.Error(codes.Internal, "auth0 management client not initialized")
 	}
 
+	if s.plan != nil {
+		return s.planDelete(userID)
+	}
+
 	return s.mgmt.User.Delete(userID)
 }
 
+// Throttled returns the number of requests of the current operation that
+// were throttled by the Auth0 rate limits.
+func (s *Auth0Plugin) Throttled() int64 {
+	if s.rateLimit == nil {
+		return 0
+	}
+	return s.rateLimit.Throttled()
+}
+
+// Skipped returns the number of users of the current operation that did not
+// match the configured filter.
+func (s *Auth0Plugin) Skipped() int64 {
+	return atomic.LoadInt64(&s.skipped)
+}
+
 func (s *Auth0Plugin) Close() (*plugin.Stats, error) {
+	if s.cancel != nil {
+		defer s.cancel()
+	}
+
+	// plugin.Stats has no room for it, so throttling is reported in the logs
+	defer func() {
+		if throttled := s.Throttled(); throttled > 0 {
+			log.Printf("auth0 rate limits throttled %d requests", throttled)
+		}
+		if skipped := s.Skipped(); skipped > 0 {
+			log.Printf("auth0 filter skipped %d users", skipped)
+		}
+	}()
+
+	if !s.opened {
+		return nil, nil
+	}
+
 	switch s.op { //nolint : gocritic // tbd
 	case plugin.OperationTypeWrite:
-		if len(s.users) > 0 {
-			err := s.startJob()
+		return s.closeWrite()
+	case plugin.OperationTypeDelete:
+		if s.plan != nil {
+			return s.closePlan()
+		}
+	case plugin.OperationTypeRead:
+		if s.prefetch != nil {
+			s.prefetch.stop()
+			s.prefetch = nil
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		if s.delta != nil {
+			log.Printf("auth0 delta read high-water mark: %s", s.delta.highWater.UTC().Format(time.RFC3339Nano))
 		}
 
-		var errs error
-		stats := &plugin.Stats{}
-		for i := 0; i < len(s.jobs); i++ {
-			jobID := auth0.StringValue(s.jobs[i].ID)
-			err := s.waitJob(jobID)
-			if err != nil {
-				errs = multierror.Append(errs, err)
-			} else {
-				auth0Stats, err := retrieveJobSummary(s.mgmt, jobID)
-				if err == nil {
-					stats = appendStats(stats, auth0Stats)
-				}
-			}
+		if s.export != nil {
+			err := s.export.Close()
+			s.export = nil
+			return nil, err
 		}
-		return stats, errs
 	}
 
 	return nil, nil
 }
 
-func (s *Auth0Plugin) waitJob(jobID string) error {
-	for {
-		j, err := s.mgmt.Job.Read(jobID)
+// closeWrite imports the last batch, waits for the import jobs, then assigns
+// the roles of the imported users and reconciles the connection.
+func (s *Auth0Plugin) closeWrite() (*plugin.Stats, error) {
+	if !s.batch.empty() {
+		err := s.startJob()
+
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+
+	if s.plan != nil {
+		return s.closePlan()
+	}
+
+	stats, notImported, errs := s.importStats()
+
+	if s.Config.AssignRoles {
+		failed, err := s.assignRoles(notImported)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		stats.Errors += failed
+	}
+
+	if s.reconciling() {
+		removed, failed, err := s.reconcile(len(notImported) > 0)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if s.Config.Reconcile == config.ReconcileBlock {
+			stats.Updated += removed
+		} else {
+			stats.Deleted += removed
+		}
+		stats.Errors += failed
+	}
+
+	return stats, errs
+}
+
+// importStats waits for the import jobs and returns their stats, the
+// identifiers of the users they did not import, and their errors. All the
+// users of a job are reported as not imported when the job did not complete
+// or its outcome is unknown.
+func (s *Auth0Plugin) importStats() (*plugin.Stats, map[string]bool, error) {
+	var errs error
+	// filtered users are received but never imported, plugin.Stats has
+	// no skipped count of its own
+	stats := &plugin.Stats{Received: int32(s.Skipped())}
+	notImported := make(map[string]bool)
+	for i, result := range s.waitJobs() {
+		job := &s.jobs[i]
+		if result.err != nil {
+			addJobUsers(notImported, job)
+			errs = multierror.Append(errs, result.err)
+			continue
 		}
 
-		switch *j.Status {
-		case "pending":
-			time.Sleep(1 * time.Second)
+		auth0Stats, err := retrieveJobSummary(s.mgmt, job.GetID())
+		if err != nil {
+			addJobUsers(notImported, job)
 			continue
-		case "failed":
-			return fmt.Errorf("job %s failed", jobID)
-		case "completed":
-			return nil
-		default:
-			return fmt.Errorf("unknown status")
+		}
+		stats = appendStats(stats, auth0Stats)
+
+		if failed, _ := auth0Stats["failed"].(float64); failed > 0 {
+			importErrs, err := s.importErrors(job)
+			if err != nil {
+				addJobUsers(notImported, job)
+				errs = multierror.Append(errs, err)
+			}
+			for _, importErr := range importErrs {
+				notImported[importErr.User] = true
+				errs = multierror.Append(errs, importErr)
+			}
 		}
 	}
+
+	return stats, notImported, errs
+}
+
+// addJobUsers adds the identifiers of the users submitted with the job.
+func addJobUsers(users map[string]bool, job *management.Job) {
+	for _, user := range job.Users {
+		users[userIdentifier(user)] = true
+	}
 }
 
 func (s *Auth0Plugin) startJob() error {
+	if s.plan != nil {
+		return s.planImport(s.batch.flush())
+	}
+
 	job := &management.Job{
 		ConnectionID:        auth0.String(s.connectionID),
 		Upsert:              auth0.Bool(true),
 		SendCompletionEmail: auth0.Bool(false),
-		Users:               s.users,
+		Users:               s.batch.flush(),
 	}
-	s.wg.Add(1)
-	defer s.wg.Done()
 	err := s.mgmt.Job.ImportUsers(job)
 	if err != nil {
 		return err
@@ -269,18 +649,27 @@ func (s *Auth0Plugin) startJob() error {
 	return nil
 }
 
-func structToMap(in interface{}) (map[string]interface{}, int64, error) {
+func structToMap(in interface{}) (map[string]interface{}, error) {
 	data, err := json.Marshal(in)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	res := make(map[string]interface{})
 	err = json.Unmarshal(data, &res)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	return res, nil
+}
+
+// jsonSize returns the size of the serialized user map, which is what counts
+// towards the import file size.
+func jsonSize(userMap map[string]interface{}) (int64, error) {
+	data, err := json.Marshal(userMap)
+	if err != nil {
+		return 0, err
 	}
-	size := int64(len(data))
-	return res, size, nil
+	return int64(len(data)), nil
 }
 
 func retrieveJobSummary(mngmt *management.Management, jobID string) (map[string]interface{}, error) {
@@ -2,6 +2,7 @@ package srv
 
 import (
 	"io"
+	"os"
 	"testing"
 
 	"github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/config"
@@ -12,18 +13,42 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func CreateConfig() config.Auth0Config {
+// CreateConfig returns the configuration of a fake Auth0 tenant seeded with
+// the test fixtures. When AUTH0_LIVE_TESTS is set, it returns the
+// configuration of the Auth0 test tenant instead, and no fake.
+func CreateConfig(t *testing.T) (config.Auth0Config, *auth0TestUtils.FakeAuth0) {
+	if os.Getenv("AUTH0_LIVE_TESTS") != "" {
+		return config.Auth0Config{
+			Domain:       testutil.VaultValue("auth0-idp-test-account.domain"),
+			ClientID:     testutil.VaultValue("auth0-idp-test-account.client-id"),
+			ClientSecret: testutil.VaultValue("auth0-idp-test-account.client-secret"),
+		}, nil
+	}
+
+	fake := auth0TestUtils.NewFakeAuth0(t)
+
 	return config.Auth0Config{
-		Domain:       testutil.VaultValue("auth0-idp-test-account.domain"),
-		ClientID:     testutil.VaultValue("auth0-idp-test-account.client-id"),
-		ClientSecret: testutil.VaultValue("auth0-idp-test-account.client-secret"),
+		Domain:       fake.Domain(),
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}, fake
+}
+
+// createFakeConfig returns the configuration of the fake Auth0 tenant, like
+// CreateConfig, skipping the tests depending on the fake or its fixtures
+// when running against the Auth0 test tenant.
+func createFakeConfig(t *testing.T) (config.Auth0Config, *auth0TestUtils.FakeAuth0) {
+	cfg, fake := CreateConfig(t)
+	if fake == nil {
+		t.Skip("depends on the fake Auth0 tenant")
 	}
+	return cfg, fake
 }
 
 func TestOpen(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
 
@@ -36,11 +61,35 @@ func TestOpen(t *testing.T) {
 	assert.Nil(stats)
 }
 
+func TestOpenInvalidClientAssertionKey(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := CreateConfig(t)
+	cfg.ClientSecret = ""
+	cfg.ClientAssertionKeyFile = "/nonexistent.pem"
+	cfg.Offline = true
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.Nil(err)
+
+	auth0Plugin := NewAuth0Plugin()
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.Error(err)
+	assert.Contains(err.Error(), "/nonexistent.pem")
+
+	stats, err := auth0Plugin.Close()
+	assert.Nil(err)
+	assert.Nil(stats)
+}
+
 func TestWrite(t *testing.T) {
 	assert := require.New(t)
 
 	apiUser := auth0TestUtils.CreateTestAPIUser("2ff319e101e1", "Test User", "user@test.com", "https://github.com/aserto-demo/contoso-ad-sample/raw/main/UserImages/Euan%20Garden.jpg")
-	cfg := CreateConfig()
+	cfg, fake := CreateConfig(t)
+	if fake != nil {
+		// the user is part of the fixtures, remove it so it gets created
+		fake.RemoveUser("auth0|2ff319e101e1")
+	}
 	err := cfg.Validate(plugin.OperationTypeWrite)
 	assert.Nil(err)
 
@@ -62,7 +111,7 @@ func TestWrite(t *testing.T) {
 func TestReadInvalidUserID(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	cfg.UserPID = "somerandomID"
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
@@ -87,7 +136,7 @@ func TestReadInvalidUserID(t *testing.T) {
 func TestReadUserByID(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	cfg.UserPID = "2ff319e101e1"
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.NoError(err)
@@ -115,7 +164,7 @@ func TestReadUserByID(t *testing.T) {
 func TestReadInvalidUserEmail(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	cfg.UserEmail = "invalidID"
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
@@ -140,7 +189,7 @@ func TestReadInvalidUserEmail(t *testing.T) {
 func TestReadUserByEmail(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	cfg.UserEmail = "user@test.com"
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
@@ -167,7 +216,7 @@ func TestReadUserByEmail(t *testing.T) {
 func TestRead(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
 
@@ -191,7 +240,7 @@ func TestRead(t *testing.T) {
 func TestDelete(t *testing.T) {
 	assert := require.New(t)
 
-	cfg := CreateConfig()
+	cfg, _ := CreateConfig(t)
 	err := cfg.Validate(plugin.OperationTypeRead)
 	assert.Nil(err)
 
@@ -235,3 +284,93 @@ func TestDelete(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(stats)
 }
+
+func TestReadThrottled(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	fake.Throttle(2)
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(3, len(users))
+	assert.Equal(int64(2), auth0Plugin.Throttled())
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+}
+
+func TestReadFiltered(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.Filter = `user.email_verified && domain(user.email) == "test.com"`
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	auth0Plugin := NewAuth0Plugin()
+	err = auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Equal(2, len(users))
+	for _, user := range users {
+		assert.NotEqual("chris.chavez@test.com", user.Email)
+	}
+	assert.Equal(int64(1), auth0Plugin.Skipped())
+
+	_, err = auth0Plugin.Close()
+	assert.NoError(err)
+}
+
+func TestReadUserByIDFiltered(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, _ := createFakeConfig(t)
+	cfg.UserPID = "auth0|2ff319e101e1"
+	cfg.Filter = `user.email != "user@test.com"`
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeRead)
+	assert.NoError(err)
+
+	users, err := auth0Plugin.Read()
+	assert.NoError(err)
+	assert.Empty(users)
+
+	_, err = auth0Plugin.Read()
+	assert.Equal(io.EOF, err)
+}
+
+func TestWriteFiltered(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, fake := createFakeConfig(t)
+	cfg.Filter = `"admin" not in user.roles`
+
+	auth0Plugin := NewAuth0Plugin()
+	err := auth0Plugin.Open(&cfg, plugin.OperationTypeWrite)
+	assert.NoError(err)
+
+	admin := auth0TestUtils.CreateTestAPIUser("1", "Admin", "admin@test.com", "pic")
+	admin.Attributes.Roles = []string{"admin"}
+	assert.NoError(auth0Plugin.Write(admin))
+	assert.NoError(auth0Plugin.Write(auth0TestUtils.CreateTestAPIUser("2", "Viewer", "viewer@test.com", "pic")))
+
+	stats, err := auth0Plugin.Close()
+	assert.NoError(err)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int64(1), auth0Plugin.Skipped())
+
+	imports := fake.Imports()
+	assert.Equal(1, len(imports))
+	assert.Equal(1, len(imports[0]))
+	assert.Equal("viewer@test.com", imports[0][0]["email"])
+}
@@ -0,0 +1,1143 @@
+package testutils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	defaultPerPage = 50
+	// maxSearchResults is the number of users the list endpoint can page
+	// through for a single query.
+	maxSearchResults = 1000
+)
+
+// FakeAuth0 is an in-memory fake of the Auth0 Management API endpoints used
+// by the plugin. Creating one points the default HTTP transport at it for the
+// duration of the test, so clients created for its Domain reach it.
+type FakeAuth0 struct {
+	Server *httptest.Server
+
+	mu          sync.Mutex
+	connections map[string]string
+	users       map[string]map[string]interface{}
+	roles       map[string]*fakeRole
+	userRoles   map[string][]string
+	jobs        map[string]*fakeJob
+	jobPolls    int
+	throttle    int
+	failing     map[string]bool
+	imports     [][]map[string]interface{}
+	logs        []map[string]interface{}
+	tokens      []url.Values
+	bearer      string
+	scopes      []string
+	lastID      int
+
+	// job polls are delayed outside of mu, so concurrent polls overlap
+	pollMu        sync.Mutex
+	pollDelay     time.Duration
+	polling       int
+	maxConcurrent int
+}
+
+type fakeRole struct {
+	id          string
+	name        string
+	permissions []map[string]interface{}
+}
+
+type fakeJob struct {
+	id           string
+	kind         string
+	status       string
+	connectionID string
+	summary      map[string]int
+	errors       []map[string]interface{}
+	polls        int
+	// export is the gzipped NDJSON file produced by export jobs.
+	export []byte
+}
+
+// NewFakeAuth0 starts a fake Auth0 tenant with a single
+// Username-Password-Authentication database connection, seeded with the
+// fixture users and roles.
+func NewFakeAuth0(t *testing.T) *FakeAuth0 {
+	f := &FakeAuth0{
+		connections: map[string]string{"Username-Password-Authentication": "con_1"},
+		users:       make(map[string]map[string]interface{}),
+		roles:       make(map[string]*fakeRole),
+		userRoles:   make(map[string][]string),
+		jobs:        make(map[string]*fakeJob),
+		scopes: []string{
+			"read:users", "create:users", "update:users", "delete:users", "read:connections",
+			"read:roles", "create:roles", "create:role_members", "read:logs",
+		},
+	}
+	f.seed()
+
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(f.serveHTTP))
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = f.Server.Client().Transport
+
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		f.Server.Close()
+	})
+
+	return f
+}
+
+// seed adds the fixture users and roles to the fake tenant.
+func (f *FakeAuth0) seed() {
+	created := time.Date(2022, time.January, 10, 9, 0, 0, 0, time.UTC)
+
+	f.AddUser(map[string]interface{}{
+		"user_id":        "auth0|2ff319e101e1",
+		"email":          "user@test.com",
+		"email_verified": true,
+		"nickname":       "Test User",
+		"name":           "Test User",
+		"picture":        "https://github.com/aserto-demo/contoso-ad-sample/raw/main/UserImages/Euan%20Garden.jpg",
+		"created_at":     created.Format(time.RFC3339),
+		"updated_at":     created.Format(time.RFC3339),
+	})
+	f.AddUser(map[string]interface{}{
+		"user_id":        "auth0|6b0dbf0a8f2b",
+		"email":          "april.stewart@test.com",
+		"email_verified": true,
+		"nickname":       "April Stewart",
+		"name":           "April Stewart",
+		"user_metadata":  map[string]interface{}{"department": "Sales Engagement Management"},
+		"app_metadata":   map[string]interface{}{"tenant": "acme"},
+		"created_at":     created.Add(time.Hour).Format(time.RFC3339),
+		"updated_at":     created.Add(48 * time.Hour).Format(time.RFC3339),
+	})
+	f.AddUser(map[string]interface{}{
+		"user_id":        "auth0|b3c4e7f3c8a1",
+		"email":          "chris.chavez@test.com",
+		"email_verified": false,
+		"nickname":       "Chris Chavez",
+		"name":           "Chris Chavez",
+		"created_at":     created.Add(2 * time.Hour).Format(time.RFC3339),
+		"updated_at":     created.Add(24 * time.Hour).Format(time.RFC3339),
+	})
+
+	f.roles["rol_1"] = &fakeRole{
+		id:   "rol_1",
+		name: "admin",
+		permissions: []map[string]interface{}{
+			{"permission_name": "read:reports", "resource_server_identifier": "https://api.test.com", "resource_server_name": "Test API"},
+			{"permission_name": "manage:tenant", "resource_server_identifier": "https://other.test.com", "resource_server_name": "Other API"},
+		},
+	}
+	f.roles["rol_2"] = &fakeRole{id: "rol_2", name: "viewer"}
+	f.userRoles["auth0|6b0dbf0a8f2b"] = []string{"rol_1"}
+}
+
+// Domain returns the domain the fake tenant is reachable at.
+func (f *FakeAuth0) Domain() string {
+	return f.Server.Listener.Addr().String()
+}
+
+// AddUser adds or replaces a user in the database connection of the tenant.
+func (f *FakeAuth0) AddUser(user map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addUser(user)
+}
+
+// RemoveUser removes a user from the tenant.
+func (f *FakeAuth0) RemoveUser(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.users, id)
+	delete(f.userRoles, id)
+}
+
+// User returns a user of the tenant, or nil if it doesn't exist.
+func (f *FakeAuth0) User(id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.users[id]
+}
+
+// UserRoles returns the names of the roles assigned to a user.
+func (f *FakeAuth0) UserRoles(id string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var names []string
+	for _, roleID := range f.userRoles[id] {
+		names = append(names, f.roles[roleID].name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SetJobPolls sets the number of times new jobs are reported as pending or
+// processing before they complete.
+func (f *FakeAuth0) SetJobPolls(polls int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.jobPolls = polls
+}
+
+// SetJobPollDelay delays the responses to job polls, so polls of several
+// jobs overlap when they are concurrent.
+func (f *FakeAuth0) SetJobPollDelay(delay time.Duration) {
+	f.pollMu.Lock()
+	defer f.pollMu.Unlock()
+
+	f.pollDelay = delay
+}
+
+// MaxConcurrentJobPolls returns the largest number of job polls that were in
+// flight at the same time.
+func (f *FakeAuth0) MaxConcurrentJobPolls() int {
+	f.pollMu.Lock()
+	defer f.pollMu.Unlock()
+
+	return f.maxConcurrent
+}
+
+func (f *FakeAuth0) startJobPoll() {
+	f.pollMu.Lock()
+	f.polling++
+	if f.polling > f.maxConcurrent {
+		f.maxConcurrent = f.polling
+	}
+	delay := f.pollDelay
+	f.pollMu.Unlock()
+
+	time.Sleep(delay)
+}
+
+func (f *FakeAuth0) endJobPoll() {
+	f.pollMu.Lock()
+	defer f.pollMu.Unlock()
+
+	f.polling--
+}
+
+// Imports returns the users submitted by every import job, in order.
+func (f *FakeAuth0) Imports() [][]map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.imports
+}
+
+// TokenRequests returns the form parameters of the access token requests
+// received so far, including the client credentials sent in the
+// authorization header.
+func (f *FakeAuth0) TokenRequests() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.tokens
+}
+
+// SetScopes sets the scopes granted by the access tokens of the fake tenant,
+// all the scopes used by the plugin by default.
+func (f *FakeAuth0) SetScopes(scopes ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scopes = scopes
+}
+
+// accessToken returns an unsigned access token granting the scopes of the
+// fake tenant.
+func (f *FakeAuth0) accessToken() string {
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   f.Server.URL + "/",
+		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+		"scope": strings.Join(f.scopes, " "),
+	})
+
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims) + ".fake-signature"
+}
+
+// Bearer returns the access token of the last Management API request.
+func (f *FakeAuth0) Bearer() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.bearer
+}
+
+// Throttle makes the next requests to the Management API fail with 429 Too
+// Many Requests, asking to retry right away.
+func (f *FakeAuth0) Throttle(requests int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttle = requests
+}
+
+// FailUser makes the requests reading the user with the given id fail with
+// 500 Internal Server Error.
+func (f *FakeAuth0) FailUser(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failing == nil {
+		f.failing = make(map[string]bool)
+	}
+	f.failing[id] = true
+}
+
+// AddLog appends an entry to the tenant logs, assigning it the next log id
+// and the current date unless they are set. It returns the log id.
+func (f *FakeAuth0) AddLog(entry map[string]interface{}) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := entry["log_id"]; !ok {
+		entry["log_id"] = fmt.Sprintf("9000%016d", len(f.logs)+1)
+	}
+	if _, ok := entry["date"]; !ok {
+		entry["date"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	f.logs = append(f.logs, entry)
+
+	return entry["log_id"].(string)
+}
+
+func (f *FakeAuth0) addUser(user map[string]interface{}) {
+	id, _ := user["user_id"].(string)
+	if _, ok := user["identities"]; !ok {
+		user["identities"] = []interface{}{
+			map[string]interface{}{
+				"connection": "Username-Password-Authentication",
+				"provider":   "auth0",
+				"user_id":    strings.TrimPrefix(id, "auth0|"),
+				"isSocial":   false,
+			},
+		}
+	}
+	f.users[id] = user
+}
+
+func (f *FakeAuth0) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && matchPath([]string{"api", "v2", "jobs", "*"}, strings.Split(strings.Trim(r.URL.Path, "/"), "/")) {
+		f.startJobPoll()
+		defer f.endJobPoll()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/oauth/token" && r.Method == http.MethodPost {
+		f.issueToken(w, r)
+		return
+	}
+
+	f.bearer = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if f.throttle > 0 {
+		f.throttle--
+		w.Header().Set("Retry-After", "0")
+		writeError(w, http.StatusTooManyRequests, "Global limit has been reached")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/exports/") && r.Method == http.MethodGet {
+		f.downloadExport(w, strings.TrimPrefix(r.URL.Path, "/exports/"))
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2"), "/"), "/")
+
+	route := findRoute(r.Method, path)
+	if route == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+
+	if !grantsAny(f.bearer, route.scopes) {
+		writeError(w, http.StatusForbidden, "Insufficient scope, expected any of: "+strings.Join(route.scopes, ","))
+		return
+	}
+
+	route.serve(f, w, r, path)
+}
+
+// issueToken serves the client credentials grant, recording the request.
+func (f *FakeAuth0) issueToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	form := r.PostForm
+	if id, secret, ok := r.BasicAuth(); ok {
+		form.Set("client_id", id)
+		form.Set("client_secret", secret)
+	}
+	f.tokens = append(f.tokens, form)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": f.accessToken(),
+		"token_type":   "Bearer",
+		"expires_in":   86400,
+	})
+}
+
+// fakeRoute is a Management API endpoint of the fake, and the scopes any of
+// which the access token must grant to call it. A "*" path segment matches
+// any value.
+type fakeRoute struct {
+	method string
+	path   []string
+	scopes []string
+	serve  func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, path []string)
+}
+
+var fakeRoutes = []fakeRoute{
+	{http.MethodGet, []string{"connections"}, []string{"read:connections"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.listConnections(w, r)
+	}},
+	{http.MethodGet, []string{"users"}, []string{"read:users"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.listUsers(w, r)
+	}},
+	{http.MethodGet, []string{"users", "*"}, []string{"read:users"}, serveUser},
+	{http.MethodPatch, []string{"users", "*"}, []string{"update:users"}, serveUser},
+	{http.MethodDelete, []string{"users", "*"}, []string{"delete:users"}, serveUser},
+	{http.MethodGet, []string{"users", "*", "roles"}, []string{"read:roles"}, serveUserRoles},
+	{http.MethodPost, []string{"users", "*", "roles"}, []string{"create:role_members"}, serveUserRoles},
+	{http.MethodGet, []string{"users", "*", "permissions"}, []string{"read:users"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, path []string) {
+		f.listUserPermissions(w, r, path[1])
+	}},
+	{http.MethodGet, []string{"users-by-email"}, []string{"read:users"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.listUsersByEmail(w, r)
+	}},
+	{http.MethodGet, []string{"roles"}, []string{"read:roles"}, serveRoles},
+	{http.MethodPost, []string{"roles"}, []string{"create:roles"}, serveRoles},
+	{http.MethodGet, []string{"logs"}, []string{"read:logs"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.listLogs(w, r)
+	}},
+	{http.MethodPost, []string{"jobs", "users-imports"}, []string{"create:users"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.importUsers(w, r)
+	}},
+	{http.MethodPost, []string{"jobs", "users-exports"}, []string{"read:users"}, func(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+		f.exportUsers(w, r)
+	}},
+	{http.MethodGet, []string{"jobs", "*"}, []string{"create:users", "read:users"}, func(f *FakeAuth0, w http.ResponseWriter, _ *http.Request, path []string) {
+		f.readJob(w, path[1])
+	}},
+	{http.MethodGet, []string{"jobs", "*", "errors"}, []string{"create:users", "read:users"}, func(f *FakeAuth0, w http.ResponseWriter, _ *http.Request, path []string) {
+		f.readJobErrors(w, path[1])
+	}},
+}
+
+func serveUser(f *FakeAuth0, w http.ResponseWriter, r *http.Request, path []string) {
+	f.serveUser(w, r, path[1])
+}
+
+func serveUserRoles(f *FakeAuth0, w http.ResponseWriter, r *http.Request, path []string) {
+	f.serveUserRoles(w, r, path[1])
+}
+
+func serveRoles(f *FakeAuth0, w http.ResponseWriter, r *http.Request, _ []string) {
+	f.serveRoles(w, r)
+}
+
+// findRoute returns the route serving the request, or nil if the fake does
+// not support it.
+func findRoute(method string, path []string) *fakeRoute {
+	for i := range fakeRoutes {
+		route := &fakeRoutes[i]
+		if route.method == method && matchPath(route.path, path) {
+			return route
+		}
+	}
+	return nil
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// grantsAny returns true if the access token grants any of the scopes.
+// Tokens that are not JWTs are not checked.
+func grantsAny(token string, scopes []string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return true
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return true
+	}
+	var claims struct {
+		Scope string `json:"scope"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return true
+	}
+
+	for _, granted := range strings.Fields(claims.Scope) {
+		for _, scope := range scopes {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *FakeAuth0) listConnections(w http.ResponseWriter, r *http.Request) {
+	connections := []interface{}{}
+
+	for name, id := range f.connections {
+		if n := r.URL.Query().Get("name"); n != "" && n != name {
+			continue
+		}
+		connections = append(connections, map[string]interface{}{"id": id, "name": name, "strategy": "auth0"})
+	}
+
+	writePage(w, r, "connections", connections)
+}
+
+func (f *FakeAuth0) listUsers(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var users []map[string]interface{}
+	for _, user := range f.users {
+		if query.matches(user) {
+			users = append(users, user)
+		}
+	}
+	sortUsers(users, r.URL.Query().Get("sort"))
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	if (page+1)*perPage > maxSearchResults {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("You can only page through the first %d records.", maxSearchResults))
+		return
+	}
+
+	items := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		items = append(items, user)
+	}
+
+	writePage(w, r, "users", items)
+}
+
+func (f *FakeAuth0) serveUser(w http.ResponseWriter, r *http.Request, id string) {
+	if f.failing[id] && r.Method == http.MethodGet {
+		writeError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	user, ok := f.users[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "The user does not exist.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, user)
+	case http.MethodPatch:
+		var update map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for key, value := range update {
+			user[key] = value
+		}
+		user["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+		writeJSON(w, http.StatusOK, user)
+	case http.MethodDelete:
+		delete(f.users, id)
+		delete(f.userRoles, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not supported")
+	}
+}
+
+func (f *FakeAuth0) serveUserRoles(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := f.users[id]; !ok {
+		writeError(w, http.StatusNotFound, "The user does not exist.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		roles := []interface{}{}
+		for _, roleID := range f.userRoles[id] {
+			roles = append(roles, f.roles[roleID].toMap())
+		}
+		writePage(w, r, "roles", roles)
+	case http.MethodPost:
+		var body struct {
+			Roles []string `json:"roles"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, roleID := range body.Roles {
+			if _, ok := f.roles[roleID]; !ok {
+				writeError(w, http.StatusNotFound, "Role not found: "+roleID)
+				return
+			}
+		}
+		f.userRoles[id] = append(f.userRoles[id], body.Roles...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not supported")
+	}
+}
+
+func (f *FakeAuth0) listUserPermissions(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := f.users[id]; !ok {
+		writeError(w, http.StatusNotFound, "The user does not exist.")
+		return
+	}
+
+	permissions := []interface{}{}
+	for _, roleID := range f.userRoles[id] {
+		for _, permission := range f.roles[roleID].permissions {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	writePage(w, r, "permissions", permissions)
+}
+
+func (f *FakeAuth0) listUsersByEmail(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+
+	users := []map[string]interface{}{}
+	for _, user := range f.users {
+		if strings.EqualFold(fmt.Sprint(user["email"]), email) {
+			users = append(users, user)
+		}
+	}
+	sortUsers(users, "")
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (f *FakeAuth0) serveRoles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ids := make([]string, 0, len(f.roles))
+		for id := range f.roles {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		roles := []interface{}{}
+		for _, id := range ids {
+			roles = append(roles, f.roles[id].toMap())
+		}
+		writePage(w, r, "roles", roles)
+	case http.MethodPost:
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		role := &fakeRole{id: fmt.Sprintf("rol_%d", len(f.roles)+1), name: body.Name}
+		f.roles[role.id] = role
+		writeJSON(w, http.StatusOK, role.toMap())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not supported")
+	}
+}
+
+// importUsers upserts the users of an import job in the tenant. Users without
+// a valid email are reported as job errors.
+// listLogs supports checkpoint pagination using from and take, and reading
+// the most recent entries using sort=date:-1.
+func (f *FakeAuth0) listLogs(w http.ResponseWriter, r *http.Request) {
+	logs := []interface{}{}
+	params := r.URL.Query()
+
+	if params.Get("from") == "" && params.Get("sort") == "date:-1" {
+		perPage, err := strconv.Atoi(params.Get("per_page"))
+		if err != nil || perPage <= 0 {
+			perPage = defaultPerPage
+		}
+		for i := len(f.logs) - 1; i >= 0 && len(logs) < perPage; i-- {
+			logs = append(logs, f.logs[i])
+		}
+		writeJSON(w, http.StatusOK, logs)
+		return
+	}
+
+	take, err := strconv.Atoi(params.Get("take"))
+	if err != nil || take <= 0 {
+		take = defaultPerPage
+	}
+	from := params.Get("from")
+	for _, entry := range f.logs {
+		if len(logs) < take && entry["log_id"].(string) > from {
+			logs = append(logs, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+func (f *FakeAuth0) importUsers(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("users")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var users []map[string]interface{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job := &fakeJob{
+		id:           fmt.Sprintf("job_%d", len(f.jobs)+1),
+		kind:         "users_import",
+		status:       "completed",
+		connectionID: r.FormValue("connection_id"),
+		summary:      map[string]int{"failed": 0, "updated": 0, "inserted": 0, "total": len(users)},
+		polls:        f.jobPolls,
+	}
+
+	for _, user := range users {
+		email, _ := user["email"].(string)
+		if !strings.Contains(email, "@") {
+			job.summary["failed"]++
+			job.errors = append(job.errors, map[string]interface{}{
+				"user": user,
+				"errors": []map[string]interface{}{
+					{"code": "INVALID_FORMAT", "message": "Object didn't pass validation for format email: " + email, "path": "email"},
+				},
+			})
+			continue
+		}
+
+		if f.upsertUser(user) {
+			job.summary["inserted"]++
+		} else {
+			job.summary["updated"]++
+		}
+	}
+
+	f.jobs[job.id] = job
+	f.imports = append(f.imports, users)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":            job.id,
+		"type":          "users_import",
+		"status":        "pending",
+		"connection_id": job.connectionID,
+	})
+}
+
+// upsertUser stores an imported user, returning true if it was created.
+func (f *FakeAuth0) upsertUser(imported map[string]interface{}) bool {
+	id := ""
+	if userID, ok := imported["user_id"].(string); ok && userID != "" {
+		id = "auth0|" + userID
+	} else {
+		for existingID, user := range f.users {
+			if user["email"] == imported["email"] {
+				id = existingID
+			}
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	user, exists := f.users[id]
+	if !exists {
+		if id == "" {
+			f.lastID++
+			id = fmt.Sprintf("auth0|imported%d", f.lastID)
+		}
+		user = map[string]interface{}{"created_at": now}
+	}
+
+	for key, value := range imported {
+		user[key] = value
+	}
+	user["user_id"] = id
+	user["updated_at"] = now
+	delete(user, "identities")
+	f.addUser(user)
+
+	return !exists
+}
+
+func (f *FakeAuth0) readJob(w http.ResponseWriter, id string) {
+	job, ok := f.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	status := job.status
+	if job.polls > 0 {
+		job.polls--
+		status = "processing"
+		if job.polls%2 == 0 {
+			status = "pending"
+		}
+	}
+
+	body := map[string]interface{}{
+		"id":            job.id,
+		"type":          job.kind,
+		"status":        status,
+		"connection_id": job.connectionID,
+		"summary":       job.summary,
+	}
+	if job.export != nil && status == "completed" {
+		body["location"] = f.Server.URL + "/exports/" + job.id
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// exportUsers creates an export job of all the users of the tenant, or of a
+// single connection. The requested fields are ignored, users are exported
+// whole.
+func (f *FakeAuth0) exportUsers(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ConnectionID string `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	connection := ""
+	for name, id := range f.connections {
+		if id == request.ConnectionID {
+			connection = name
+		}
+	}
+	if request.ConnectionID != "" && connection == "" {
+		writeError(w, http.StatusBadRequest, "connection not found")
+		return
+	}
+
+	var users []map[string]interface{}
+	for _, user := range f.users {
+		if connection == "" || inConnection(user, connection) {
+			users = append(users, user)
+		}
+	}
+	sortUsers(users, "")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, user := range users {
+		if err := encoder.Encode(user); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := gz.Close(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	job := &fakeJob{
+		id:           fmt.Sprintf("job_%d", len(f.jobs)+1),
+		kind:         "users_export",
+		status:       "completed",
+		connectionID: request.ConnectionID,
+		polls:        f.jobPolls,
+		export:       buf.Bytes(),
+	}
+	f.jobs[job.id] = job
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":            job.id,
+		"type":          job.kind,
+		"status":        "pending",
+		"connection_id": job.connectionID,
+	})
+}
+
+func (f *FakeAuth0) downloadExport(w http.ResponseWriter, id string) {
+	job, ok := f.jobs[id]
+	if !ok || job.export == nil {
+		writeError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	_, _ = w.Write(job.export)
+}
+
+func inConnection(user map[string]interface{}, connection string) bool {
+	identities, _ := user["identities"].([]interface{})
+	for _, identity := range identities {
+		if i, ok := identity.(map[string]interface{}); ok && i["connection"] == connection {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeAuth0) readJobErrors(w http.ResponseWriter, id string) {
+	job, ok := f.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	if len(job.errors) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.errors)
+}
+
+func (r *fakeRole) toMap() map[string]interface{} {
+	return map[string]interface{}{"id": r.id, "name": r.name}
+}
+
+// fakeQuery is the subset of the Auth0 user search syntax supported by the
+// fake: field:value and field:[from TO to] terms joined by AND, optionally
+// wrapped in parentheses.
+type fakeQuery []fakeTerm
+
+type fakeTerm struct {
+	field string
+	value string
+
+	// range terms
+	isRange       bool
+	from, to      string
+	fromInclusive bool
+	toInclusive   bool
+}
+
+func parseQuery(q string) (fakeQuery, error) {
+	var query fakeQuery
+
+	for _, term := range strings.Split(q, " AND ") {
+		term = strings.Trim(strings.TrimSpace(term), "()")
+		if term == "" {
+			continue
+		}
+
+		parts := strings.SplitN(term, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unsupported query term %q", term)
+		}
+
+		t, err := parseTerm(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		query = append(query, t)
+	}
+
+	return query, nil
+}
+
+func parseTerm(field, value string) (fakeTerm, error) {
+	if !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "{") {
+		return fakeTerm{field: field, value: strings.Trim(value, `"`)}, nil
+	}
+
+	bounds := strings.SplitN(value[1:len(value)-1], " TO ", 2)
+	if len(bounds) != 2 {
+		return fakeTerm{}, fmt.Errorf("unsupported range %q", value)
+	}
+
+	return fakeTerm{
+		field:         field,
+		isRange:       true,
+		from:          strings.Trim(bounds[0], `"`),
+		to:            strings.Trim(bounds[1], `"`),
+		fromInclusive: value[0] == '[',
+		toInclusive:   value[len(value)-1] == ']',
+	}, nil
+}
+
+func (q fakeQuery) matches(user map[string]interface{}) bool {
+	for _, term := range q {
+		value, ok := lookup(user, term.field)
+		if !ok || !term.matches(fmt.Sprint(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t fakeTerm) matches(value string) bool {
+	if !t.isRange {
+		return value == t.value
+	}
+
+	if t.from != "*" {
+		c := compareValues(value, t.from)
+		if c < 0 || (c == 0 && !t.fromInclusive) {
+			return false
+		}
+	}
+
+	if t.to != "*" {
+		c := compareValues(value, t.to)
+		if c > 0 || (c == 0 && !t.toInclusive) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareValues compares two values as timestamps when both are, or as
+// strings otherwise.
+func compareValues(a, b string) int {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA == nil && errB == nil {
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// lookup returns the value of a dotted path in a user.
+func lookup(user map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = user
+
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// sortUsers sorts users by the given field:order, or by id.
+func sortUsers(users []map[string]interface{}, order string) {
+	field, direction := "user_id", "1"
+	if parts := strings.SplitN(order, ":", 2); len(parts) == 2 {
+		field, direction = parts[0], parts[1]
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		c := compareValues(fmt.Sprint(users[i][field]), fmt.Sprint(users[j][field]))
+		if c == 0 {
+			// ties are sorted by id so pages are stable
+			return fmt.Sprint(users[i]["user_id"]) < fmt.Sprint(users[j]["user_id"])
+		}
+		if direction == "-1" {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// writePage writes a page of a list endpoint response, as returned when
+// include_totals is set.
+func writePage(w http.ResponseWriter, r *http.Request, key string, items []interface{}) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = defaultPerPage
+	}
+
+	start := page * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		key:      items[start:end],
+		"start":  start,
+		"limit":  perPage,
+		"length": end - start,
+		"total":  len(items),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"statusCode": status,
+		"error":      http.StatusText(status),
+		"message":    message,
+	})
+}
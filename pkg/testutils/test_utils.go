@@ -1,7 +1,6 @@
 package testutils
 
 import (
-	"strings"
 	"time"
 
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
@@ -37,14 +36,14 @@ func CreateTestAuth0User(id, displayName, email, picture, phoneNo, userName stri
 
 	metadata := make(map[string]interface{})
 	metadata["phoneNumber"] = phoneNo
-	metadata[strings.ToLower(api.IdentityKind_IDENTITY_KIND_PHONE.String())] = phoneNo
-	metadata[strings.ToLower(api.IdentityKind_IDENTITY_KIND_USERNAME.String())] = userName
 
 	user := management.User{
 		ID:           auth0.String(id),
 		Nickname:     auth0.String(displayName),
 		Email:        auth0.String(email),
 		Picture:      auth0.String(picture),
+		PhoneNumber:  auth0.String(phoneNo),
+		Username:     auth0.String(userName),
 		UserMetadata: metadata,
 	}
 
@@ -0,0 +1,93 @@
+package transform
+
+import (
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// AppMetadataValuesApplication is the application holding the app_metadata
+// values that are not objects when every app_metadata object is mapped to an
+// application, so they are written back along with the applications.
+const AppMetadataValuesApplication = "_app_metadata"
+
+// applicationsFromAppMetadata maps the Auth0 app_metadata to Aserto
+// applications, according to the transform options.
+func applicationsFromAppMetadata(appMetadata map[string]interface{}, opts *transformOptions) map[string]*api.AttrSet {
+	applications := make(map[string]*api.AttrSet)
+
+	if len(appMetadata) == 0 {
+		return applications
+	}
+
+	if opts.appMetadataApplication != "" {
+		props, err := structpb.NewStruct(appMetadata)
+		if err == nil {
+			applications[opts.appMetadataApplication] = newAttrSet(props)
+		}
+		return applications
+	}
+
+	if opts.appMetadataPerApplication {
+		values := make(map[string]interface{})
+		for name, value := range appMetadata {
+			obj, ok := value.(map[string]interface{})
+			if !ok || name == AppMetadataValuesApplication {
+				values[name] = value
+				continue
+			}
+
+			props, err := structpb.NewStruct(obj)
+			if err == nil {
+				applications[name] = newAttrSet(props)
+			}
+		}
+
+		if len(values) != 0 {
+			props, err := structpb.NewStruct(values)
+			if err == nil {
+				applications[AppMetadataValuesApplication] = newAttrSet(props)
+			}
+		}
+	}
+
+	return applications
+}
+
+// appMetadataFromApplications is the reverse of applicationsFromAppMetadata.
+func appMetadataFromApplications(applications map[string]*api.AttrSet, opts *transformOptions) map[string]interface{} {
+	if opts.appMetadataApplication != "" {
+		app := applications[opts.appMetadataApplication]
+		if app == nil || app.Properties == nil {
+			return nil
+		}
+		return app.Properties.AsMap()
+	}
+
+	if !opts.appMetadataPerApplication || len(applications) == 0 {
+		return nil
+	}
+
+	appMetadata := make(map[string]interface{})
+	for name, app := range applications {
+		if app == nil || app.Properties == nil || name == AppMetadataValuesApplication {
+			continue
+		}
+		appMetadata[name] = app.Properties.AsMap()
+	}
+
+	if values := applications[AppMetadataValuesApplication]; values != nil && values.Properties != nil {
+		for name, value := range values.Properties.AsMap() {
+			appMetadata[name] = value
+		}
+	}
+
+	return appMetadata
+}
+
+func newAttrSet(props *structpb.Struct) *api.AttrSet {
+	return &api.AttrSet{
+		Properties:  props,
+		Roles:       []string{},
+		Permissions: []string{},
+	}
+}
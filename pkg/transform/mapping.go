@@ -0,0 +1,501 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/auth0.v5"
+	"gopkg.in/auth0.v5/management"
+	"gopkg.in/yaml.v2"
+)
+
+// Field transforms supported by the mappings.
+const (
+	// FieldTransformNone copies the value of a single source.
+	FieldTransformNone = ""
+	// FieldTransformLower lower cases the value of a single source.
+	FieldTransformLower = "lower"
+	// FieldTransformUpper upper cases the value of a single source.
+	FieldTransformUpper = "upper"
+	// FieldTransformTrim trims the spaces around the value of a single
+	// source.
+	FieldTransformTrim = "trim"
+	// FieldTransformJoin joins the non-empty values of the sources with the
+	// separator, a space by default.
+	FieldTransformJoin = "join"
+	// FieldTransformFirst takes the value of the first non-empty source.
+	FieldTransformFirst = "first"
+)
+
+// Mapping declares how user fields are mapped between Auth0 and Aserto users,
+// on top of the default mapping.
+//
+// Auth0 paths are the JSON names of the user profile fields, like name or
+// given_name, or paths in the user_metadata and app_metadata, like
+// app_metadata.department. Aserto paths are display_name, email, picture,
+// paths in the user properties, like properties.department, or paths in the
+// properties of an application, like applications.portal.department.
+type Mapping struct {
+	// ToAserto maps Auth0 paths to Aserto paths, applied by Transform.
+	ToAserto []FieldMapping `json:"to_aserto" yaml:"to_aserto"`
+	// ToAuth0 maps Aserto paths to Auth0 paths, applied by ToAuth0.
+	ToAuth0 []FieldMapping `json:"to_auth0" yaml:"to_auth0"`
+}
+
+// FieldMapping maps the values at the source paths to the target path.
+type FieldMapping struct {
+	Source    string   `json:"source" yaml:"source"`
+	Sources   []string `json:"sources" yaml:"sources"`
+	Target    string   `json:"target" yaml:"target"`
+	Transform string   `json:"transform" yaml:"transform"`
+	Separator string   `json:"separator" yaml:"separator"`
+}
+
+// auth0Fields are the Auth0 user profile fields that can be mapped, and
+// whether they can be written.
+var auth0Fields = map[string]bool{ // nolint:gochecknoglobals // read only
+	"user_id":        false,
+	"email":          true,
+	"email_verified": false,
+	"username":       true,
+	"phone_number":   false,
+	"name":           true,
+	"given_name":     true,
+	"family_name":    true,
+	"nickname":       true,
+	"picture":        true,
+	"blocked":        false,
+	"last_login":     false,
+	"logins_count":   false,
+	"created_at":     false,
+	"updated_at":     false,
+}
+
+// asertoFields are the Aserto user fields that can be mapped, besides the
+// properties.
+var asertoFields = map[string]bool{ // nolint:gochecknoglobals // read only
+	"display_name": true,
+	"email":        true,
+	"picture":      true,
+}
+
+// LoadMapping reads a mapping spec from a JSON or YAML file and validates it.
+// Files with a .json extension are read as JSON, others as YAML.
+func LoadMapping(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mapping{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(m)
+	} else {
+		err = yaml.UnmarshalStrict(data, m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Validate checks that the mapping only uses supported paths and transforms.
+func (m *Mapping) Validate() error {
+	for i, f := range m.ToAserto {
+		if err := f.validate(validAuth0Path, validAsertoPath); err != nil {
+			return fmt.Errorf("to_aserto[%d]: %w", i, err)
+		}
+	}
+
+	for i, f := range m.ToAuth0 {
+		if err := f.validate(validAsertoPath, validAuth0Target); err != nil {
+			return fmt.Errorf("to_auth0[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *FieldMapping) validate(validSource, validTarget func(string) bool) error {
+	if f.Source != "" && len(f.Sources) != 0 {
+		return fmt.Errorf("source and sources can not be combined")
+	}
+
+	sources := f.sources()
+	if len(sources) == 0 {
+		return fmt.Errorf("no source was provided")
+	}
+
+	for _, source := range sources {
+		if !validSource(source) {
+			return fmt.Errorf("unsupported source %q", source)
+		}
+	}
+
+	if f.Target == "" {
+		return fmt.Errorf("no target was provided")
+	}
+	if !validTarget(f.Target) {
+		return fmt.Errorf("unsupported target %q", f.Target)
+	}
+
+	switch f.Transform {
+	case FieldTransformNone, FieldTransformLower, FieldTransformUpper, FieldTransformTrim:
+		if len(sources) != 1 {
+			return fmt.Errorf("a single source is required without a join or first transform")
+		}
+	case FieldTransformJoin, FieldTransformFirst:
+	default:
+		return fmt.Errorf("unsupported transform %q", f.Transform)
+	}
+
+	if f.Separator != "" && f.Transform != FieldTransformJoin {
+		return fmt.Errorf("a separator can only be used with the join transform")
+	}
+
+	return nil
+}
+
+func (f *FieldMapping) sources() []string {
+	if f.Source != "" {
+		return []string{f.Source}
+	}
+	return f.Sources
+}
+
+// value returns the mapped value from the source fields, or nil if they are
+// empty.
+func (f *FieldMapping) value(fields map[string]interface{}) (interface{}, error) {
+	var values []interface{}
+	var found []string
+	for _, source := range f.sources() {
+		if value, ok := lookupPath(fields, source); ok && !isEmpty(value) {
+			values = append(values, value)
+			found = append(found, source)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	switch f.Transform {
+	case FieldTransformFirst, FieldTransformNone:
+		return values[0], nil
+	case FieldTransformJoin:
+		separator := f.Separator
+		if separator == "" {
+			separator = " "
+		}
+
+		parts := make([]string, 0, len(values))
+		for i, value := range values {
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s can not be joined, it is a %T", found[i], value)
+			}
+			parts = append(parts, str)
+		}
+		return strings.Join(parts, separator), nil
+	}
+
+	str, ok := values[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s transform requires %s to be a string, not %T", f.Transform, found[0], values[0])
+	}
+
+	switch f.Transform {
+	case FieldTransformLower:
+		return strings.ToLower(str), nil
+	case FieldTransformUpper:
+		return strings.ToUpper(str), nil
+	default:
+		return strings.TrimSpace(str), nil
+	}
+}
+
+// toAserto applies the ToAserto mappings to an Aserto user.
+func (m *Mapping) toAserto(in *management.User, out *api.User) error {
+	if len(m.ToAserto) == 0 {
+		return nil
+	}
+
+	fields, err := auth0UserFields(in)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range m.ToAserto {
+		value, err := f.value(fields)
+		if err != nil {
+			return fmt.Errorf("failed to map %s: %w", f.Target, err)
+		}
+		if value == nil {
+			continue
+		}
+
+		if err := setAsertoField(out, f.Target, value); err != nil {
+			return fmt.Errorf("failed to map %s: %w", f.Target, err)
+		}
+	}
+
+	return nil
+}
+
+// toAuth0 applies the ToAuth0 mappings to an Auth0 user.
+func (m *Mapping) toAuth0(in *api.User, out *management.User) error {
+	fields := asertoUserFields(in)
+
+	for _, f := range m.ToAuth0 {
+		value, err := f.value(fields)
+		if err != nil {
+			return fmt.Errorf("failed to map %s: %w", f.Target, err)
+		}
+		if value == nil {
+			continue
+		}
+
+		if err := setAuth0Field(out, f.Target, value); err != nil {
+			return fmt.Errorf("failed to map %s: %w", f.Target, err)
+		}
+	}
+
+	return nil
+}
+
+func validAuth0Path(path string) bool {
+	if _, ok := auth0Fields[path]; ok {
+		return true
+	}
+	return isMetadataPath(path)
+}
+
+func validAuth0Target(path string) bool {
+	return auth0Fields[path] || isMetadataPath(path)
+}
+
+func isMetadataPath(path string) bool {
+	parts := strings.SplitN(path, ".", 2)
+	return len(parts) == 2 && parts[1] != "" && (parts[0] == "user_metadata" || parts[0] == "app_metadata")
+}
+
+func validAsertoPath(path string) bool {
+	if asertoFields[path] {
+		return true
+	}
+
+	parts := strings.Split(path, ".")
+	switch parts[0] {
+	case "properties":
+		return len(parts) >= 2 && !hasEmpty(parts)
+	case "applications":
+		return len(parts) >= 3 && !hasEmpty(parts)
+	default:
+		return false
+	}
+}
+
+func hasEmpty(parts []string) bool {
+	for _, part := range parts {
+		if part == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// auth0UserFields returns the fields of an Auth0 user as they are serialized.
+func auth0UserFields(in *management.User) (map[string]interface{}, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// asertoUserFields returns the fields of an Aserto user using the mapping
+// paths.
+func asertoUserFields(in *api.User) map[string]interface{} {
+	fields := map[string]interface{}{
+		"display_name": in.DisplayName,
+		"email":        in.Email,
+		"picture":      in.Picture,
+	}
+
+	if in.Attributes != nil && in.Attributes.Properties != nil {
+		fields["properties"] = in.Attributes.Properties.AsMap()
+	}
+
+	applications := make(map[string]interface{})
+	for name, app := range in.Applications {
+		if app != nil && app.Properties != nil {
+			applications[name] = app.Properties.AsMap()
+		}
+	}
+	fields["applications"] = applications
+
+	return fields
+}
+
+func setAsertoField(user *api.User, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+
+	switch parts[0] {
+	case "display_name", "email", "picture":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("value must be a string, not %T", value)
+		}
+		switch parts[0] {
+		case "display_name":
+			user.DisplayName = str
+		case "email":
+			user.Email = str
+		default:
+			user.Picture = str
+		}
+		return nil
+	case "properties":
+		if user.Attributes == nil {
+			user.Attributes = &api.AttrSet{}
+		}
+		props, err := setStructPath(user.Attributes.Properties, parts[1:], value)
+		if err != nil {
+			return err
+		}
+		user.Attributes.Properties = props
+		return nil
+	default:
+		if user.Applications == nil {
+			user.Applications = make(map[string]*api.AttrSet)
+		}
+		app := user.Applications[parts[1]]
+		if app == nil {
+			app = newAttrSet(nil)
+			user.Applications[parts[1]] = app
+		}
+		props, err := setStructPath(app.Properties, parts[2:], value)
+		if err != nil {
+			return err
+		}
+		app.Properties = props
+		return nil
+	}
+}
+
+func setAuth0Field(user *management.User, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+
+	switch parts[0] {
+	case "user_metadata":
+		if user.UserMetadata == nil {
+			user.UserMetadata = make(map[string]interface{})
+		}
+		setPath(user.UserMetadata, parts[1:], value)
+		return nil
+	case "app_metadata":
+		if user.AppMetadata == nil {
+			user.AppMetadata = make(map[string]interface{})
+		}
+		setPath(user.AppMetadata, parts[1:], value)
+		return nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("value must be a string, not %T", value)
+	}
+
+	switch path {
+	case "email":
+		user.Email = auth0.String(str)
+	case "username":
+		user.Username = auth0.String(str)
+	case "name":
+		user.Name = auth0.String(str)
+	case "given_name":
+		user.GivenName = auth0.String(str)
+	case "family_name":
+		user.FamilyName = auth0.String(str)
+	case "nickname":
+		user.Nickname = auth0.String(str)
+	case "picture":
+		user.Picture = auth0.String(str)
+	}
+
+	return nil
+}
+
+// setStructPath returns the properties with the value set at path.
+func setStructPath(props *structpb.Struct, path []string, value interface{}) (*structpb.Struct, error) {
+	fields := make(map[string]interface{})
+	if props != nil {
+		fields = props.AsMap()
+	}
+
+	setPath(fields, path, value)
+
+	return structpb.NewStruct(fields)
+}
+
+// lookupPath returns the value at a dotted path.
+func lookupPath(fields map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = fields
+
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// setPath sets the value at path, replacing the values that are not objects
+// along the way.
+func setPath(fields map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := fields[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			fields[key] = next
+		}
+		fields = next
+	}
+
+	fields[path[len(path)-1]] = value
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	default:
+		return false
+	}
+}
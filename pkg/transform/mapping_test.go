@@ -0,0 +1,141 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/auth0.v5"
+)
+
+const yamlMapping = `
+to_aserto:
+  - sources: [given_name, family_name]
+    transform: join
+    target: display_name
+  - source: app_metadata.department
+    target: properties.department
+  - source: email
+    transform: lower
+    target: applications.portal.login
+to_auth0:
+  - source: display_name
+    target: name
+  - source: properties.department
+    target: app_metadata.department
+`
+
+const jsonMapping = `{
+  "to_aserto": [
+    {"sources": ["name", "nickname"], "transform": "first", "target": "display_name"}
+  ]
+}`
+
+func writeMapping(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestMappingToAserto(t *testing.T) {
+	assert := require.New(t)
+	mapping, err := LoadMapping(writeMapping(t, "mapping.yaml", yamlMapping))
+	assert.NoError(err)
+
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "April@Test.com", "pic", "+40722332233", "userName")
+	auth0User.GivenName = auth0.String("April")
+	auth0User.FamilyName = auth0.String("Stewart")
+	auth0User.AppMetadata = map[string]interface{}{"department": "Sales"}
+
+	apiUser, err := Transform(auth0User, WithMapping(mapping))
+	assert.NoError(err)
+	assert.Equal("April Stewart", apiUser.DisplayName)
+	assert.Equal("Sales", apiUser.Attributes.Properties.Fields["department"].GetStringValue())
+	assert.Equal("+40722332233", apiUser.Attributes.Properties.Fields["phoneNumber"].GetStringValue(), "should keep the default mapping")
+	assert.Equal("april@test.com", apiUser.Applications["portal"].Properties.Fields["login"].GetStringValue())
+
+	auth0User.GivenName = nil
+	apiUser, err = Transform(auth0User, WithMapping(mapping))
+	assert.NoError(err)
+	assert.Equal("Stewart", apiUser.DisplayName, "should skip empty sources")
+}
+
+func TestMappingToAsertoFromJSON(t *testing.T) {
+	assert := require.New(t)
+	mapping, err := LoadMapping(writeMapping(t, "mapping.json", jsonMapping))
+	assert.NoError(err)
+
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Nick", "email", "pic", "", "")
+	apiUser, err := Transform(auth0User, WithMapping(mapping))
+	assert.NoError(err)
+	assert.Equal("Nick", apiUser.DisplayName)
+
+	auth0User.Name = auth0.String("April Stewart")
+	apiUser, err = Transform(auth0User, WithMapping(mapping))
+	assert.NoError(err)
+	assert.Equal("April Stewart", apiUser.DisplayName)
+}
+
+func TestMappingToAuth0(t *testing.T) {
+	assert := require.New(t)
+	mapping, err := LoadMapping(writeMapping(t, "mapping.yml", yamlMapping))
+	assert.NoError(err)
+
+	apiUser := auth0TestUtils.CreateTestAPIUser("1", "April Stewart", "email", "pic")
+	apiUser.Attributes.Properties.Fields["department"] = structpb.NewStringValue("Sales")
+
+	auth0User, err := ToAuth0(apiUser, WithMapping(mapping))
+	assert.NoError(err)
+	assert.Equal("April Stewart", auth0User.GetName())
+	assert.Equal("April Stewart", auth0User.GetNickname(), "should keep the default mapping")
+	assert.Equal(map[string]interface{}{"department": "Sales"}, auth0User.AppMetadata)
+}
+
+func TestMappingValueErrors(t *testing.T) {
+	assert := require.New(t)
+	mapping := &Mapping{
+		ToAserto: []FieldMapping{{Source: "user_metadata.code", Transform: FieldTransformUpper, Target: "properties.code"}},
+	}
+	assert.NoError(mapping.Validate())
+
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "", "")
+	auth0User.UserMetadata["code"] = 42.0
+
+	_, err := Transform(auth0User, WithMapping(mapping))
+	assert.Error(err)
+	assert.Equal("failed to map properties.code: upper transform requires user_metadata.code to be a string, not float64", err.Error())
+}
+
+func TestLoadInvalidMapping(t *testing.T) {
+	assert := require.New(t)
+
+	mappings := map[string]string{
+		"to_aserto[0]: unsupported source \"password\"":                               "to_aserto: [{source: password, target: display_name}]",
+		"to_aserto[0]: unsupported target \"id\"":                                     "to_aserto: [{source: name, target: id}]",
+		"to_aserto[0]: no source was provided":                                        "to_aserto: [{target: display_name}]",
+		"to_aserto[0]: no target was provided":                                        "to_aserto: [{source: name}]",
+		"to_aserto[0]: source and sources can not be combined":                        "to_aserto: [{source: name, sources: [nickname], target: display_name}]",
+		"to_aserto[0]: unsupported transform \"reverse\"":                             "to_aserto: [{source: name, transform: reverse, target: display_name}]",
+		"to_aserto[0]: a single source is required without a join or first transform": "to_aserto: [{sources: [name, nickname], target: display_name}]",
+		"to_aserto[0]: a separator can only be used with the join transform":          "to_aserto: [{source: name, separator: '-', target: display_name}]",
+		"to_auth0[0]: unsupported target \"user_id\"":                                 "to_auth0: [{source: display_name, target: user_id}]",
+		"to_auth0[0]: unsupported source \"applications.portal\"":                     "to_auth0: [{source: applications.portal, target: name}]",
+	}
+
+	for expected, content := range mappings {
+		_, err := LoadMapping(writeMapping(t, "mapping.yaml", content))
+		assert.Error(err, expected)
+		assert.Equal(expected, err.Error())
+	}
+
+	_, err := LoadMapping(writeMapping(t, "mapping.yaml", "to_asserto: []"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "field to_asserto not found")
+
+	_, err = LoadMapping(writeMapping(t, "mapping.json", `{"to_asserto": []}`))
+	assert.Error(err)
+	assert.Contains(err.Error(), `unknown field "to_asserto"`)
+}
@@ -4,6 +4,16 @@ type Option func(*transformOptions)
 
 type transformOptions struct {
 	userID bool
+	// application the whole app_metadata is mapped to
+	appMetadataApplication string
+	// map each app_metadata object to the application with the same name
+	appMetadataPerApplication bool
+	// property holding the password hash, not copied to the user_metadata
+	passwordHashProperty string
+	// read the phone and username missing from the profile from user_metadata
+	metadataIdentities bool
+	// field mappings applied on top of the default mapping
+	mapping *Mapping
 }
 
 // Also pass user id when transforming object
@@ -12,3 +22,42 @@ func WithUserID() Option {
 		o.userID = true
 	}
 }
+
+// Map the whole app_metadata to the properties of the given application
+func WithAppMetadata(application string) Option {
+	return func(o *transformOptions) {
+		o.appMetadataApplication = application
+		o.appMetadataPerApplication = false
+	}
+}
+
+// Map every object in app_metadata to the properties of the application
+// with the same name, and the other values to AppMetadataValuesApplication
+func WithAppMetadataPerApplication() Option {
+	return func(o *transformOptions) {
+		o.appMetadataApplication = ""
+		o.appMetadataPerApplication = true
+	}
+}
+
+// Do not copy the property holding the password hash to the user_metadata
+func WithPasswordHash(property string) Option {
+	return func(o *transformOptions) {
+		o.passwordHashProperty = property
+	}
+}
+
+// Read the phone number and username from the user_metadata when they are
+// not part of the Auth0 user profile
+func WithMetadataIdentities() Option {
+	return func(o *transformOptions) {
+		o.metadataIdentities = true
+	}
+}
+
+// Apply the field mappings on top of the default mapping
+func WithMapping(mapping *Mapping) Option {
+	return func(o *transformOptions) {
+		o.mapping = mapping
+	}
+}
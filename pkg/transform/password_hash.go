@@ -0,0 +1,216 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// hashAlgorithms are the algorithms supported by the Auth0
+// custom_password_hash import field.
+var hashAlgorithms = map[string]bool{ // nolint:gochecknoglobals // read only
+	"argon2": true,
+	"bcrypt": true,
+	"hmac":   true,
+	"ldap":   true,
+	"md4":    true,
+	"md5":    true,
+	"sha1":   true,
+	"sha256": true,
+	"sha512": true,
+	"pbkdf2": true,
+}
+
+// hashPrefixes are the prefixes of the hashes that must be provided in their
+// encoded string format.
+var hashPrefixes = map[string][]string{ // nolint:gochecknoglobals // read only
+	"argon2": {"$argon2"},
+	"bcrypt": {"$2a$", "$2b$", "$2y$"},
+	"pbkdf2": {"$pbkdf2"},
+	"ldap":   {"{"},
+}
+
+var hmacDigests = map[string]bool{ // nolint:gochecknoglobals // read only
+	"md4":       true,
+	"md5":       true,
+	"ripemd160": true,
+	"sha1":      true,
+	"sha224":    true,
+	"sha256":    true,
+	"sha384":    true,
+	"sha512":    true,
+	"whirlpool": true,
+}
+
+var valueEncodings = map[string]bool{ // nolint:gochecknoglobals // read only
+	"base64": true,
+	"hex":    true,
+	"utf8":   true,
+}
+
+var passwordEncodings = map[string]bool{ // nolint:gochecknoglobals // read only
+	"ascii":   true,
+	"utf8":    true,
+	"utf16le": true,
+	"ucs2":    true,
+	"latin1":  true,
+	"binary":  true,
+}
+
+// PasswordHash returns the Auth0 custom_password_hash of an Aserto user, read
+// from the given attribute property. The property holds the hash in the
+// format expected by Auth0, either as an object or as a JSON string. It
+// returns nil if the user has no password hash.
+func PasswordHash(in *api.User, property string) (map[string]interface{}, error) {
+	if in.Attributes == nil || in.Attributes.Properties == nil {
+		return nil, nil
+	}
+
+	value, ok := in.Attributes.Properties.Fields[property]
+	if !ok {
+		return nil, nil
+	}
+
+	var hash map[string]interface{}
+	switch v := value.Kind.(type) {
+	case *structpb.Value_StructValue:
+		hash = v.StructValue.AsMap()
+	case *structpb.Value_StringValue:
+		if err := json.Unmarshal([]byte(v.StringValue), &hash); err != nil {
+			return nil, fmt.Errorf("password hash is not a valid JSON object")
+		}
+	default:
+		return nil, fmt.Errorf("password hash must be an object")
+	}
+
+	if err := ValidatePasswordHash(hash); err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// ValidatePasswordHash checks that a custom_password_hash uses a supported
+// algorithm and encodings. Hash values are never included in the errors.
+func ValidatePasswordHash(hash map[string]interface{}) error {
+	algorithm, _ := hash["algorithm"].(string)
+	if !hashAlgorithms[algorithm] {
+		return fmt.Errorf("unsupported password hash algorithm %q", algorithm)
+	}
+
+	h, ok := hash["hash"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("password hash has no hash object")
+	}
+
+	value, _ := h["value"].(string)
+	if value == "" {
+		return fmt.Errorf("password hash has no hash value")
+	}
+
+	encoding, err := optionalEnum(h, "encoding", valueEncodings)
+	if err != nil {
+		return fmt.Errorf("invalid hash encoding: %w", err)
+	}
+
+	if prefixes, ok := hashPrefixes[algorithm]; ok {
+		if encoding != "" && encoding != "utf8" {
+			return fmt.Errorf("%s hashes must be utf8 encoded", algorithm)
+		}
+		if !hasAnyPrefix(value, prefixes) {
+			return fmt.Errorf("hash value is not a valid %s hash", algorithm)
+		}
+	}
+
+	if algorithm == "hmac" {
+		if err := validateHMAC(h); err != nil {
+			return err
+		}
+	}
+
+	if salt, ok := hash["salt"]; ok {
+		if err := validateSalt(salt); err != nil {
+			return err
+		}
+	}
+
+	if password, ok := hash["password"].(map[string]interface{}); ok {
+		if _, err := optionalEnum(password, "encoding", passwordEncodings); err != nil {
+			return fmt.Errorf("invalid password encoding: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func validateHMAC(h map[string]interface{}) error {
+	digest, _ := h["digest"].(string)
+	if !hmacDigests[digest] {
+		return fmt.Errorf("unsupported hmac digest %q", digest)
+	}
+
+	key, ok := h["key"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("hmac hashes require a key")
+	}
+
+	if value, _ := key["value"].(string); value == "" {
+		return fmt.Errorf("hmac key has no value")
+	}
+
+	if _, err := optionalEnum(key, "encoding", valueEncodings); err != nil {
+		return fmt.Errorf("invalid hmac key encoding: %w", err)
+	}
+
+	return nil
+}
+
+func validateSalt(s interface{}) error {
+	salt, ok := s.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("password hash salt must be an object")
+	}
+
+	if value, _ := salt["value"].(string); value == "" {
+		return fmt.Errorf("password hash salt has no value")
+	}
+
+	if _, err := optionalEnum(salt, "encoding", valueEncodings); err != nil {
+		return fmt.Errorf("invalid salt encoding: %w", err)
+	}
+
+	position, _ := salt["position"].(string)
+	if position != "" && position != "prefix" && position != "suffix" {
+		return fmt.Errorf("invalid salt position %q; expected prefix or suffix", position)
+	}
+
+	return nil
+}
+
+// optionalEnum returns the value of an optional string field, checking it is
+// one of the allowed values.
+func optionalEnum(obj map[string]interface{}, field string, allowed map[string]bool) (string, error) {
+	raw, ok := obj[field]
+	if !ok {
+		return "", nil
+	}
+
+	value, ok := raw.(string)
+	if !ok || !allowed[value] {
+		return "", fmt.Errorf("unsupported %s %v", field, raw)
+	}
+
+	return value, nil
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package transform
+
+import (
+	"testing"
+
+	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const bcryptHash = "$2b$10$C9hx7yhTYmgjb6ghmmD3zOZWTLh4FCrDpUxPKRY9tcGOJtKzGvmGy"
+
+func TestPasswordHash(t *testing.T) {
+	assert := require.New(t)
+	apiUser := auth0TestUtils.CreateTestAPIUser("1", "Name", "email", "pic")
+	hash, err := structpb.NewValue(map[string]interface{}{
+		"algorithm": "bcrypt",
+		"hash":      map[string]interface{}{"value": bcryptHash},
+	})
+	assert.NoError(err)
+	apiUser.Attributes.Properties.Fields["password_hash"] = hash
+	apiUser.Attributes.Properties.Fields["department"] = structpb.NewStringValue("sales")
+
+	customHash, err := PasswordHash(apiUser, "password_hash")
+	assert.NoError(err)
+	assert.Equal("bcrypt", customHash["algorithm"])
+
+	auth0User, err := ToAuth0(apiUser, WithPasswordHash("password_hash"))
+	assert.NoError(err)
+	assert.NotContains(auth0User.UserMetadata, "password_hash", "should not leak the hash to the user_metadata")
+	assert.Equal("sales", auth0User.UserMetadata["department"])
+}
+
+func TestPasswordHashFromString(t *testing.T) {
+	assert := require.New(t)
+	apiUser := auth0TestUtils.CreateTestAPIUser("1", "Name", "email", "pic")
+	apiUser.Attributes.Properties.Fields["password_hash"] = structpb.NewStringValue(
+		`{"algorithm": "sha256", "hash": {"value": "d24e7d1b", "encoding": "hex"}, "salt": {"value": "abc", "position": "suffix"}}`)
+
+	customHash, err := PasswordHash(apiUser, "password_hash")
+	assert.NoError(err)
+	assert.Equal("sha256", customHash["algorithm"])
+}
+
+func TestPasswordHashMissing(t *testing.T) {
+	assert := require.New(t)
+	apiUser := auth0TestUtils.CreateTestAPIUser("1", "Name", "email", "pic")
+
+	customHash, err := PasswordHash(apiUser, "password_hash")
+	assert.NoError(err)
+	assert.Nil(customHash)
+}
+
+func TestValidatePasswordHash(t *testing.T) {
+	assert := require.New(t)
+	hashes := map[string]map[string]interface{}{
+		`unsupported password hash algorithm "rot13"`: {
+			"algorithm": "rot13",
+			"hash":      map[string]interface{}{"value": "x"},
+		},
+		"password hash has no hash value": {
+			"algorithm": "sha1",
+			"hash":      map[string]interface{}{},
+		},
+		"bcrypt hashes must be utf8 encoded": {
+			"algorithm": "bcrypt",
+			"hash":      map[string]interface{}{"value": bcryptHash, "encoding": "base64"},
+		},
+		"hash value is not a valid bcrypt hash": {
+			"algorithm": "bcrypt",
+			"hash":      map[string]interface{}{"value": "plaintext"},
+		},
+		`unsupported hmac digest ""`: {
+			"algorithm": "hmac",
+			"hash":      map[string]interface{}{"value": "abc", "key": map[string]interface{}{"value": "key"}},
+		},
+		"hmac hashes require a key": {
+			"algorithm": "hmac",
+			"hash":      map[string]interface{}{"value": "abc", "digest": "sha256"},
+		},
+		"invalid hash encoding: unsupported encoding base32": {
+			"algorithm": "md5",
+			"hash":      map[string]interface{}{"value": "abc", "encoding": "base32"},
+		},
+		`invalid salt position "middle"; expected prefix or suffix`: {
+			"algorithm": "sha512",
+			"hash":      map[string]interface{}{"value": "abc"},
+			"salt":      map[string]interface{}{"value": "salt", "position": "middle"},
+		},
+		"invalid password encoding: unsupported encoding ebcdic": {
+			"algorithm": "sha512",
+			"hash":      map[string]interface{}{"value": "abc"},
+			"password":  map[string]interface{}{"encoding": "ebcdic"},
+		},
+	}
+
+	for expected, hash := range hashes {
+		err := ValidatePasswordHash(hash)
+		assert.Error(err, expected)
+		assert.Equal(expected, err.Error())
+		assert.NotContains(err.Error(), bcryptHash, "should not include the hash value")
+	}
+}
@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"fmt"
 	"strings"
 
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
@@ -15,7 +16,7 @@ const (
 	Provider = "auth0"
 )
 
-func ToAuth0(in *api.User, args ...Option) *management.User {
+func ToAuth0(in *api.User, args ...Option) (*management.User, error) {
 	opts := &transformOptions{}
 
 	for _, arg := range args {
@@ -30,6 +31,9 @@ func ToAuth0(in *api.User, args ...Option) *management.User {
 
 	if in.Attributes != nil && in.Attributes.Properties != nil {
 		user.UserMetadata = in.Attributes.Properties.AsMap()
+		if opts.passwordHashProperty != "" {
+			delete(user.UserMetadata, opts.passwordHashProperty)
+		}
 	}
 
 	for key, value := range in.Identities {
@@ -39,15 +43,31 @@ func ToAuth0(in *api.User, args ...Option) *management.User {
 		}
 	}
 
+	if appMetadata := appMetadataFromApplications(in.Applications, opts); len(appMetadata) != 0 {
+		user.AppMetadata = appMetadata
+	}
+
 	if opts.userID {
 		user.ID = auth0.String(in.Id)
 	}
 
-	return &user
+	if opts.mapping != nil {
+		if err := opts.mapping.toAuth0(in, &user); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
 }
 
 // Transform Auth0 user definition into Aserto Edge User object definition.
-func Transform(in *management.User) *api.User {
+func Transform(in *management.User, args ...Option) (*api.User, error) {
+	opts := &transformOptions{}
+
+	for _, arg := range args {
+		arg(opts)
+	}
+
 	user := api.User{
 		DisplayName: in.GetNickname(),
 		Email:       in.GetEmail(),
@@ -58,41 +78,60 @@ func Transform(in *management.User) *api.User {
 			Roles:       []string{},
 			Permissions: []string{},
 		},
-		Applications: make(map[string]*api.AttrSet),
+		Applications: applicationsFromAppMetadata(in.AppMetadata, opts),
 		Metadata: &api.Metadata{
 			CreatedAt: timestamppb.New(in.GetCreatedAt()),
 			UpdatedAt: timestamppb.New(in.GetUpdatedAt()),
 		},
 	}
 
+	provider := addLinkedIdentities(&user, in)
+
 	user.Identities[in.GetID()] = &api.IdentitySource{
 		Kind:     api.IdentityKind_IDENTITY_KIND_PID,
-		Provider: Provider,
+		Provider: provider,
 		Verified: true,
 	}
 
 	user.Identities[in.GetEmail()] = &api.IdentitySource{
 		Kind:     api.IdentityKind_IDENTITY_KIND_EMAIL,
-		Provider: Provider,
+		Provider: provider,
 		Verified: in.GetEmailVerified(),
 	}
 
-	phoneProp := strings.ToLower(api.IdentityKind_IDENTITY_KIND_PHONE.String())
-	if in.UserMetadata[phoneProp] != nil {
-		phone := in.UserMetadata[phoneProp].(string)
+	phone, phoneVerified := in.GetPhoneNumber(), in.GetPhoneVerified()
+	username := in.GetUsername()
+
+	if opts.metadataIdentities {
+		var err error
+
+		if phone == "" {
+			phone, err = metadataString(in.UserMetadata, strings.ToLower(api.IdentityKind_IDENTITY_KIND_PHONE.String()))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if username == "" {
+			username, err = metadataString(in.UserMetadata, strings.ToLower(api.IdentityKind_IDENTITY_KIND_USERNAME.String()))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if phone != "" {
 		user.Identities[phone] = &api.IdentitySource{
 			Kind:     api.IdentityKind_IDENTITY_KIND_PHONE,
-			Provider: Provider,
-			Verified: false,
+			Provider: provider,
+			Verified: phoneVerified,
 		}
 	}
 
-	usernameProp := strings.ToLower(api.IdentityKind_IDENTITY_KIND_USERNAME.String())
-	if in.UserMetadata[usernameProp] != nil {
-		username := in.UserMetadata[usernameProp].(string)
+	if username != "" {
 		user.Identities[username] = &api.IdentitySource{
 			Kind:     api.IdentityKind_IDENTITY_KIND_USERNAME,
-			Provider: Provider,
+			Provider: provider,
 			Verified: false,
 		}
 	}
@@ -104,5 +143,53 @@ func Transform(in *management.User) *api.User {
 		}
 	}
 
-	return &user
+	if opts.mapping != nil {
+		if err := opts.mapping.toAserto(in, &user); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// metadataString returns the string stored under key in the user_metadata,
+// or an empty string if there is none.
+func metadataString(metadata map[string]interface{}, key string) (string, error) {
+	value, ok := metadata[key]
+	if !ok || value == nil {
+		return "", nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("user_metadata %s must be a string, not %T", key, value)
+	}
+	return str, nil
+}
+
+// addLinkedIdentities adds a PID identity for every identity linked to the
+// Auth0 user, keyed by provider|user_id like the Auth0 user ids. It returns
+// the provider of the primary identity, or Provider when the user has no
+// identities.
+func addLinkedIdentities(user *api.User, in *management.User) string {
+	provider := Provider
+
+	for _, identity := range in.Identities {
+		if identity.GetProvider() == "" || identity.GetUserID() == "" {
+			continue
+		}
+
+		id := identity.GetProvider() + "|" + identity.GetUserID()
+		if id == in.GetID() {
+			provider = identity.GetProvider()
+		}
+
+		user.Identities[id] = &api.IdentitySource{
+			Kind:     api.IdentityKind_IDENTITY_KIND_PID,
+			Provider: identity.GetProvider(),
+			Verified: true,
+		}
+	}
+
+	return provider
 }
@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	auth0TestUtils "github.com/aserto-dev/aserto-idp-plugin-auth0/pkg/testutils"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/auth0.v5"
 	"gopkg.in/auth0.v5/management"
 )
 
@@ -13,7 +15,8 @@ func TestTransformToAuth0(t *testing.T) {
 	assert := require.New(t)
 	apiUser := auth0TestUtils.CreateTestAPIUser("1", "Name", "email", "pic")
 
-	auth0User := ToAuth0(apiUser, WithUserID())
+	auth0User, err := ToAuth0(apiUser, WithUserID())
+	assert.NoError(err)
 
 	assert.True(reflect.TypeOf(*auth0User) == reflect.TypeOf(management.User{}), "the returned object should be *management.User")
 	assert.Equal("Name", (*auth0User).GetNickname(), "should correctly detect the nickname")
@@ -26,7 +29,8 @@ func TestTransformToAuth0NoID(t *testing.T) {
 	assert := require.New(t)
 	apiUser := auth0TestUtils.CreateTestAPIUser("1", "Name", "email", "pic")
 
-	auth0User := ToAuth0(apiUser)
+	auth0User, err := ToAuth0(apiUser)
+	assert.NoError(err)
 
 	assert.True(reflect.TypeOf(*auth0User) == reflect.TypeOf(management.User{}), "the returned object should be *management.User")
 	assert.Equal("Name", (*auth0User).GetNickname(), "should correctly detect the nickname")
@@ -39,7 +43,8 @@ func TestTransform(t *testing.T) {
 	assert := require.New(t)
 	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
 
-	apiUser := Transform(auth0User)
+	apiUser, err := Transform(auth0User)
+	assert.NoError(err)
 
 	assert.Empty(apiUser.Id, "should not populate the id")
 	assert.Equal("Name", apiUser.DisplayName, "should correctly detect the displayname")
@@ -50,3 +55,144 @@ func TestTransform(t *testing.T) {
 	assert.False(apiUser.Identities["userName"].Verified)
 	assert.Equal("+40722332233", apiUser.Attributes.Properties.Fields["phoneNumber"].GetStringValue())
 }
+
+func TestTransformPhoneVerified(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.PhoneVerified = auth0.Bool(true)
+
+	apiUser, err := Transform(auth0User)
+	assert.NoError(err)
+
+	assert.Equal(api.IdentityKind_IDENTITY_KIND_PHONE, apiUser.Identities["+40722332233"].Kind)
+	assert.True(apiUser.Identities["+40722332233"].Verified, "should preserve the phone verification")
+}
+
+func TestTransformMetadataIdentities(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "", "")
+	auth0User.PhoneNumber = nil
+	auth0User.Username = nil
+	auth0User.UserMetadata["identity_kind_phone"] = "+40722332244"
+	auth0User.UserMetadata["identity_kind_username"] = "metaName"
+
+	apiUser, err := Transform(auth0User)
+	assert.NoError(err)
+	assert.Equal(2, len(apiUser.Identities), "should ignore the user_metadata by default")
+
+	apiUser, err = Transform(auth0User, WithMetadataIdentities())
+	assert.NoError(err)
+	assert.Equal(4, len(apiUser.Identities))
+	assert.Equal(api.IdentityKind_IDENTITY_KIND_PHONE, apiUser.Identities["+40722332244"].Kind)
+	assert.False(apiUser.Identities["+40722332244"].Verified)
+	assert.Equal(api.IdentityKind_IDENTITY_KIND_USERNAME, apiUser.Identities["metaName"].Kind)
+
+	auth0User.Username = auth0.String("userName")
+	apiUser, err = Transform(auth0User, WithMetadataIdentities())
+	assert.NoError(err)
+	assert.NotNil(apiUser.Identities["userName"], "should prefer the profile username")
+	assert.Nil(apiUser.Identities["metaName"])
+}
+
+func TestTransformMalformedMetadataIdentities(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "", "")
+	auth0User.PhoneNumber = nil
+	auth0User.UserMetadata["identity_kind_phone"] = 40722332244.0
+
+	_, err := Transform(auth0User, WithMetadataIdentities())
+	assert.Error(err)
+	assert.Equal("user_metadata identity_kind_phone must be a string, not float64", err.Error())
+}
+
+func TestTransformLinkedIdentities(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("google-oauth2|1042", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.Identities = []*management.UserIdentity{
+		{Provider: auth0.String("google-oauth2"), Connection: auth0.String("google-oauth2"), UserID: auth0.String("1042"), IsSocial: auth0.Bool(true)},
+		{Provider: auth0.String("github"), Connection: auth0.String("github"), UserID: auth0.String("73"), IsSocial: auth0.Bool(true)},
+		{Provider: auth0.String("samlp"), Connection: auth0.String("acme-saml"), UserID: auth0.String("acme-saml|jdoe"), IsSocial: auth0.Bool(false)},
+	}
+
+	apiUser, err := Transform(auth0User)
+	assert.NoError(err)
+
+	assert.Equal(6, len(apiUser.Identities))
+	assert.Equal("google-oauth2", apiUser.Identities["google-oauth2|1042"].Provider)
+	assert.Equal(api.IdentityKind_IDENTITY_KIND_PID, apiUser.Identities["google-oauth2|1042"].Kind)
+	assert.Equal("github", apiUser.Identities["github|73"].Provider)
+	assert.Equal(api.IdentityKind_IDENTITY_KIND_PID, apiUser.Identities["github|73"].Kind)
+	assert.True(apiUser.Identities["github|73"].Verified)
+	assert.Equal("samlp", apiUser.Identities["samlp|acme-saml|jdoe"].Provider)
+	assert.Equal("google-oauth2", apiUser.Identities["email"].Provider, "should use the provider of the primary identity")
+}
+
+func TestTransformAppMetadataWhole(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.AppMetadata = map[string]interface{}{"tenant": "acme", "plan": "gold"}
+
+	apiUser, err := Transform(auth0User, WithAppMetadata("portal"))
+	assert.NoError(err)
+
+	assert.Equal(1, len(apiUser.Applications))
+	assert.Equal("acme", apiUser.Applications["portal"].Properties.Fields["tenant"].GetStringValue())
+	assert.Equal("gold", apiUser.Applications["portal"].Properties.Fields["plan"].GetStringValue())
+
+	roundTrip, err := ToAuth0(apiUser, WithAppMetadata("portal"))
+	assert.NoError(err)
+	assert.Equal(auth0User.AppMetadata, roundTrip.AppMetadata)
+}
+
+func TestTransformAppMetadataPerApplication(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.AppMetadata = map[string]interface{}{
+		"portal":  map[string]interface{}{"tenant": "acme"},
+		"billing": map[string]interface{}{"plan": "gold"},
+		"flag":    true,
+	}
+
+	apiUser, err := Transform(auth0User, WithAppMetadataPerApplication())
+	assert.NoError(err)
+
+	assert.Equal(3, len(apiUser.Applications))
+	assert.Equal("acme", apiUser.Applications["portal"].Properties.Fields["tenant"].GetStringValue())
+	assert.Equal("gold", apiUser.Applications["billing"].Properties.Fields["plan"].GetStringValue())
+	assert.True(apiUser.Applications[AppMetadataValuesApplication].Properties.Fields["flag"].GetBoolValue(), "values that are not objects should be kept apart")
+
+	roundTrip, err := ToAuth0(apiUser, WithAppMetadataPerApplication())
+	assert.NoError(err)
+	assert.Equal(auth0User.AppMetadata, roundTrip.AppMetadata)
+}
+
+func TestTransformAppMetadataPerApplicationReservedKey(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.AppMetadata = map[string]interface{}{
+		AppMetadataValuesApplication: map[string]interface{}{"tenant": "acme"},
+		"count":                      float64(3),
+	}
+
+	apiUser, err := Transform(auth0User, WithAppMetadataPerApplication())
+	assert.NoError(err)
+	assert.Equal(1, len(apiUser.Applications))
+
+	roundTrip, err := ToAuth0(apiUser, WithAppMetadataPerApplication())
+	assert.NoError(err)
+	assert.Equal(auth0User.AppMetadata, roundTrip.AppMetadata)
+}
+
+func TestTransformAppMetadataIgnored(t *testing.T) {
+	assert := require.New(t)
+	auth0User := auth0TestUtils.CreateTestAuth0User("1", "Name", "email", "pic", "+40722332233", "userName")
+	auth0User.AppMetadata = map[string]interface{}{"tenant": "acme"}
+
+	apiUser, err := Transform(auth0User)
+	assert.NoError(err)
+
+	assert.Empty(apiUser.Applications)
+	roundTrip, err := ToAuth0(apiUser)
+	assert.NoError(err)
+	assert.Nil(roundTrip.AppMetadata)
+}